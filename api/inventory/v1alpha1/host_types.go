@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HostState describes whether a host can be claimed
+type HostState string
+
+const (
+	HostStateAvailable HostState = "Available"
+	HostStateClaimed   HostState = "Claimed"
+	HostStateDisabled  HostState = "Disabled"
+)
+
+// HostSpec describes a pre-registered host
+type HostSpec struct {
+	// Name of the HostPool in the same namespace the host belongs to
+	PoolName string `json:"poolName"`
+
+	// Addresses written to the claiming machine
+	// +optional
+	Addresses []corev1.NodeAddress `json:"addresses,omitempty"`
+
+	// MAC addresses of the host interfaces
+	// +optional
+	MACAddresses []string `json:"macAddresses,omitempty"`
+
+	// Capacity of the host, used for the scale from zero annotations of MachineSets
+	// +optional
+	Capacity corev1.ResourceList `json:"capacity,omitempty"`
+
+	// ProviderID written to the claiming machine
+	// +optional
+	ProviderID string `json:"providerID,omitempty"`
+
+	// Disabled hosts are not claimed, a claimed host keeps its machine
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// HostStatus records the machine holding the host
+type HostStatus struct {
+	// +optional
+	State HostState `json:"state,omitempty"`
+
+	// Machine that claimed the host
+	// +optional
+	MachineRef *corev1.ObjectReference `json:"machineRef,omitempty"`
+
+	// +optional
+	ClaimedAt *metav1.Time `json:"claimedAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Pool",type=string,JSONPath=`.spec.poolName`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Machine",type=string,JSONPath=`.status.machineRef.name`
+
+// Host is a machine that can be claimed from a HostPool
+type Host struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HostSpec   `json:"spec,omitempty"`
+	Status HostStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HostList contains a list of Host
+type HostList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Host `json:"items"`
+}
+
+// Claimable reports whether the host can be claimed by a new machine
+func (h *Host) Claimable() bool {
+	return !h.Spec.Disabled && h.Status.MachineRef == nil && h.DeletionTimestamp.IsZero()
+}
+
+func init() {
+	SchemeBuilder.Register(&Host{}, &HostList{})
+}
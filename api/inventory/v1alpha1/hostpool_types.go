@@ -0,0 +1,75 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HostPoolSpec selects the machines that claim hosts from the pool
+type HostPoolSpec struct {
+	// Machines in the same namespace matching the selector claim hosts from the pool
+	// Ex. machine.openshift.io/cluster-api-machineset: worker-baremetal
+	MachineSelector metav1.LabelSelector `json:"machineSelector"`
+}
+
+// HostPoolStatus counts the hosts of the pool
+type HostPoolStatus struct {
+	// +optional
+	Hosts int32 `json:"hosts"`
+	// +optional
+	Available int32 `json:"available"`
+	// +optional
+	Claimed int32 `json:"claimed"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Hosts",type=integer,JSONPath=`.status.hosts`
+//+kubebuilder:printcolumn:name="Available",type=integer,JSONPath=`.status.available`
+//+kubebuilder:printcolumn:name="Claimed",type=integer,JSONPath=`.status.claimed`
+
+// HostPool is a set of pre-registered hosts claimed by machines
+type HostPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HostPoolSpec   `json:"spec,omitempty"`
+	Status HostPoolStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HostPoolList contains a list of HostPool
+type HostPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HostPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HostPool{}, &HostPoolList{})
+}
@@ -0,0 +1,135 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RemediationStrategy is how an unhealthy machine is remediated
+// +kubebuilder:validation:Enum=Hook;BMCReset;Reprovision;Mark
+type RemediationStrategy string
+
+const (
+	// Run the remediate action of the configured provisioner
+	RemediationStrategyHook RemediationStrategy = "Hook"
+	// Reset the host through its BMC
+	RemediationStrategyBMCReset RemediationStrategy = "BMCReset"
+	// Reprovision the machine, replacing its node
+	RemediationStrategyReprovision RemediationStrategy = "Reprovision"
+	// Only record an Event on the machine for someone to act on
+	RemediationStrategyMark RemediationStrategy = "Mark"
+)
+
+// RemediationPhase is the progress of a remediation
+type RemediationPhase string
+
+const (
+	RemediationPhaseRunning   RemediationPhase = "Running"
+	RemediationPhaseSucceeded RemediationPhase = "Succeeded"
+	RemediationPhaseFailed    RemediationPhase = "Failed"
+)
+
+// LinkerRemediationSpec sets how the machine is remediated
+type LinkerRemediationSpec struct {
+	// Strategy used to remediate the machine
+	// +kubebuilder:default=Mark
+	// +optional
+	Strategy RemediationStrategy `json:"strategy,omitempty"`
+}
+
+// LinkerRemediationStatus reports the progress of the remediation
+type LinkerRemediationStatus struct {
+	// +optional
+	Phase RemediationPhase `json:"phase,omitempty"`
+	// Details of the current phase
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// When the BMCReset strategy started the reset, the host is only reset once per remediation
+	// +optional
+	ResetTime *metav1.Time `json:"resetTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Strategy",type=string,JSONPath=`.spec.strategy`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// LinkerRemediation remediates the machine of the same name, it is created by a MachineHealthCheck
+// from a LinkerRemediationTemplate
+type LinkerRemediation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LinkerRemediationSpec   `json:"spec,omitempty"`
+	Status LinkerRemediationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LinkerRemediationList contains a list of LinkerRemediation
+type LinkerRemediationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LinkerRemediation `json:"items"`
+}
+
+// LinkerRemediationTemplateResource is the LinkerRemediation created from the template
+type LinkerRemediationTemplateResource struct {
+	Spec LinkerRemediationSpec `json:"spec"`
+}
+
+// LinkerRemediationTemplateSpec holds the LinkerRemediation created for unhealthy machines
+type LinkerRemediationTemplateSpec struct {
+	Template LinkerRemediationTemplateResource `json:"template"`
+}
+
+//+kubebuilder:object:root=true
+
+// LinkerRemediationTemplate is referenced by the remediationTemplate of a MachineHealthCheck
+type LinkerRemediationTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LinkerRemediationTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LinkerRemediationTemplateList contains a list of LinkerRemediationTemplate
+type LinkerRemediationTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LinkerRemediationTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LinkerRemediation{}, &LinkerRemediationList{}, &LinkerRemediationTemplate{}, &LinkerRemediationTemplateList{})
+}
@@ -0,0 +1,145 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/agent"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("agent")
+)
+
+func init() {
+	utilruntime.Must(machinev1.AddToScheme(scheme))
+}
+
+func main() {
+	var namespace string
+	var machineName string
+	var nodeName string
+	var interfaces string
+	var excludeInterfaces string
+	var interval time.Duration
+	flag.StringVar(&namespace, "namespace", "openshift-machine-api", "Namespace of the machines.")
+	flag.StringVar(&machineName, "machine", os.Getenv("MACHINE_NAME"),
+		"Name of the machine to annotate. Found by MAC address or hostname when empty.")
+	flag.StringVar(&nodeName, "node", os.Getenv("NODE_NAME"),
+		"Name of the node the agent runs on. Only the machine linked to it, or naming it in its node-name annotation, is annotated.")
+	flag.StringVar(&interfaces, "interfaces", "",
+		"Comma separated interface name prefixes to report. All interfaces when empty.")
+	flag.StringVar(&excludeInterfaces, "exclude-interfaces", strings.Join(agent.DefaultExcludeInterfaces, ","),
+		"Comma separated interface name prefixes never reported.")
+	flag.DurationVar(&interval, "interval", 0,
+		"How often to report. Zero reports once and exits, for use on first boot.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	a := &agent.Agent{
+		Client:      c,
+		Namespace:   namespace,
+		MachineName: machineName,
+		NodeName:    nodeName,
+	}
+	include, exclude := splitList(interfaces), splitList(excludeInterfaces)
+
+	ctx := ctrl.SetupSignalHandler()
+	for {
+		if err := report(ctx, a, include, exclude); err != nil {
+			setupLog.Error(err, "unable to report")
+			if interval == 0 {
+				os.Exit(1)
+			}
+		}
+		if interval == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func report(ctx context.Context, a *agent.Agent, include, exclude []string) error {
+	rep, err := agent.Discover(include, exclude)
+	if err != nil {
+		return err
+	}
+	m, err := a.FindMachine(ctx, rep)
+	if errors.Is(err, agent.ErrMachineNotFound) {
+		setupLog.Info("No machine matches this host", "Hostname", rep.Hostname, "MACAddresses", rep.MACAddresses)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	changed, err := a.Publish(ctx, m, rep)
+	if err != nil {
+		return err
+	}
+	if changed {
+		setupLog.Info("Updated machine", "Machine", m.Name, "Annotations", rep.Annotations())
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/controller"
+	"github.com/machine-node-linker/machine-node-linker/internal/importer"
+)
+
+func importAgentConfig(args []string) error {
+	fs := flag.NewFlagSet("import-agent-config", flag.ContinueOnError)
+	agentConfigPath := fs.String("agent-config", "", "Path of the agent-config.yaml with the hosts.")
+	installConfigPath := fs.String("install-config", "", "Path of the install-config.yaml with the cluster name, control plane replicas and baremetal hosts.")
+	opts := importer.Options{}
+	fs.StringVar(&opts.Namespace, "namespace", "openshift-machine-api", "Namespace of the generated objects.")
+	fs.StringVar(&opts.InfraID, "infra-id", "", "Infrastructure name used in object names and the cluster label. Defaults to the cluster name.")
+	providerIDTemplate := fs.String("provider-id-template", "", "Template used to set spec.providerID of the machines, as for the manager.")
+	fs.BoolVar(&opts.MachineSets, "machinesets", false, "Also generate a MachineSet adopting the machines of each role other than master.")
+	output := fs.String("output", "-", "File the manifests are written to, - for stdout.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *agentConfigPath == "" && *installConfigPath == "" {
+		return errors.New("at least one of --agent-config and --install-config is required")
+	}
+
+	var agentConfig *importer.AgentConfig
+	if *agentConfigPath != "" {
+		agentConfig = &importer.AgentConfig{}
+		if err := importer.LoadFile(*agentConfigPath, agentConfig); err != nil {
+			return err
+		}
+	}
+	var installConfig *importer.InstallConfig
+	if *installConfigPath != "" {
+		installConfig = &importer.InstallConfig{}
+		if err := importer.LoadFile(*installConfigPath, installConfig); err != nil {
+			return err
+		}
+	}
+	if *providerIDTemplate != "" {
+		var err error
+		if opts.ProviderIDTemplate, err = controller.ParseProviderIDTemplate(*providerIDTemplate); err != nil {
+			return err
+		}
+	}
+
+	objs, err := importer.Generate(agentConfig, installConfig, opts)
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := importer.WriteYAML(w, objs); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,140 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/importer"
+)
+
+func inventory(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected import or export")
+	}
+	switch args[0] {
+	case "import":
+		return inventoryImport(args[1:])
+	case "export":
+		return inventoryExport(args[1:])
+	default:
+		return fmt.Errorf("unknown inventory command %q, expected import or export", args[0])
+	}
+}
+
+func inventoryImport(args []string) error {
+	fs := flag.NewFlagSet("inventory import", flag.ContinueOnError)
+	kubeconfig := fs.String("kubeconfig", "", "Path of the kubeconfig. Defaults to KUBECONFIG, then ~/.kube/config.")
+	namespace := fs.String("namespace", "openshift-machine-api", "Namespace of the machines.")
+	file := fs.String("file", "", "Inventory to import, - for stdin.")
+	format := fs.String("format", "", "Inventory format, csv or yaml. Defaults to csv for a .csv file and yaml otherwise.")
+	dryRun := fs.Bool("dry-run", false, "Print the changes without making them.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("--file is required")
+	}
+	if *format == "" {
+		*format = importer.FormatFromPath(*file)
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	entries, err := importer.ReadInventory(r, *format)
+	if err != nil {
+		return err
+	}
+	c, err := newClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+	return importer.ImportInventory(context.Background(), c, *namespace, entries, *dryRun, os.Stdout)
+}
+
+func inventoryExport(args []string) error {
+	fs := flag.NewFlagSet("inventory export", flag.ContinueOnError)
+	kubeconfig := fs.String("kubeconfig", "", "Path of the kubeconfig. Defaults to KUBECONFIG, then ~/.kube/config.")
+	namespace := fs.String("namespace", "openshift-machine-api", "Namespace of the machines.")
+	output := fs.String("output", "-", "File the inventory is written to, - for stdout.")
+	format := fs.String("format", "", "Inventory format, csv or yaml. Defaults to csv for a .csv file and yaml otherwise.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = importer.FormatFromPath(*output)
+	}
+
+	c, err := newClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+	entries, err := importer.ExportInventory(context.Background(), c, *namespace)
+	if err != nil {
+		return err
+	}
+	w, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	if err := importer.WriteInventory(w, *format, entries); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Client for machines from the kubeconfig, the default loading rules apply when path is empty
+func newClient(path string) (client.Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
+	scheme := runtime.NewScheme()
+	if err := machinev1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
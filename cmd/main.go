@@ -26,19 +26,34 @@ SOFTWARE.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+	remediationv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/remediation/v1alpha1"
 	"github.com/machine-node-linker/machine-node-linker/internal/controller"
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
 	machinev1 "github.com/openshift/api/machine/v1beta1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -54,6 +69,8 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(machinev1.AddToScheme(scheme))
+	utilruntime.Must(inventoryv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(remediationv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -61,11 +78,123 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var providerIDTemplate string
+	var setNodeProviderID bool
+	var deleteNodes bool
+	var drainTimeout time.Duration
+	var guardControlPlane bool
+	var useActuator bool
+	var provisionCommand string
+	var provisionJobTemplate string
+	var provisionTimeout time.Duration
+	var provisionRetries int
+	var provisionURL string
+	var provisionSecret string
+	var provisionReconcile bool
+	var hostPools bool
+	var machineSetCapacity bool
+	var nodeReplacementPolicy string
+	var verifyReplacedNodeAddresses bool
+	var adoptNodes bool
+	var adoptNodeSelector string
+	var adoptNamespace string
+	var adoptMachineSet string
+	var heartbeatLeases bool
+	var heartbeatTimeout time.Duration
+	var bmcPollInterval time.Duration
+	var bareMetalHosts bool
+	var netboxURL string
+	var netboxTokenSecret string
+	var netboxCacheTTL time.Duration
+	var addressInventoryRef string
+	var addressInventoryPrecedence string
+	var remediation bool
+	var remediationReprovisionTimeout time.Duration
+	var registrationAddr string
+	var registrationCert string
+	var registrationKey string
+	var registrationInsecure bool
+	var registrationSecretNamespace string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&providerIDTemplate, "provider-id-template", "",
+		"Template used to set spec.providerID on machines without a provider-id annotation. "+
+			"Ex. baremetalhost:///{{ .Namespace }}/{{ .Name }}")
+	flag.BoolVar(&setNodeProviderID, "set-node-provider-id", false,
+		"Set spec.providerID on linked nodes that do not have one.")
+	flag.BoolVar(&deleteNodes, "delete-nodes", false,
+		"Drain and delete the linked node when a managed machine is deleted.")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 0,
+		"How long to try draining a node before deleting it anyway. Zero waits forever.")
+	flag.BoolVar(&guardControlPlane, "guard-control-plane", true,
+		"Block deletion or failure of control plane machines that would break etcd quorum.")
+	flag.BoolVar(&useActuator, "use-actuator", false,
+		"Run the machine-api-operator machine controller with the manual actuator instead of the Machine reconciler.")
+	flag.StringVar(&provisionCommand, "provision-command", "",
+		"Executable run on machine create, reprovision and delete. Receives the machine as JSON on stdin.")
+	flag.StringVar(&provisionJobTemplate, "provision-job-template", "",
+		"Path to a Job template run on machine create, reprovision and delete. Cannot be used with --provision-command.")
+	flag.StringVar(&provisionURL, "provision-url", "",
+		"URL of a provisioning service called on machine create, reprovision and delete. Cannot be used with other provisioners.")
+	flag.StringVar(&provisionSecret, "provision-secret", "",
+		"Secret (namespace/name) with the bearer token, client certificate and CA used to call --provision-url.")
+	flag.BoolVar(&provisionReconcile, "provision-reconcile", false,
+		"Also call --provision-url with the reconcile action for provisioned machines.")
+	flag.BoolVar(&hostPools, "host-pools", false,
+		"Claim a Host from a matching HostPool for machines without addresses.")
+	flag.BoolVar(&machineSetCapacity, "machineset-capacity", false,
+		"Set the cluster-autoscaler scale from zero annotations on MachineSets.")
+	flag.StringVar(&nodeReplacementPolicy, "node-replacement-policy", controller.NodeReplacementAccept,
+		"What to do when the linked node is replaced by a node with the same name: accept, fail or approve.")
+	flag.BoolVar(&verifyReplacedNodeAddresses, "verify-replaced-node-addresses", false,
+		"Require approval of a replaced node that does not report the machine addresses.")
+	flag.BoolVar(&adoptNodes, "adopt-nodes", false,
+		"Create machines for nodes that no machine is linked to.")
+	flag.StringVar(&adoptNodeSelector, "adopt-node-selector", "",
+		"Label selector limiting the nodes adopted by --adopt-nodes. Ex. node-role.kubernetes.io/worker")
+	flag.StringVar(&adoptNamespace, "adopt-namespace", "openshift-machine-api",
+		"Namespace machines for adopted nodes are created in.")
+	flag.StringVar(&adoptMachineSet, "adopt-machineset", "",
+		"MachineSet in --adopt-namespace set as the owner of machines for adopted nodes.")
+	flag.BoolVar(&heartbeatLeases, "heartbeat-leases", false,
+		"Derive the instance state of machines from a Lease named after the machine.")
+	flag.DurationVar(&heartbeatTimeout, "heartbeat-timeout", 2*time.Minute,
+		"How long a heartbeat Lease without leaseDurationSeconds stays fresh after its last renewal.")
+	flag.DurationVar(&bmcPollInterval, "bmc-poll-interval", time.Minute,
+		"How often the power state is read from the BMC of machines with a bmc-address annotation.")
+	flag.BoolVar(&bareMetalHosts, "watch-baremetalhosts", false,
+		"Watch the metal3 BareMetalHosts referenced by machines instead of polling them, requires the metal3 CRDs")
+	flag.StringVar(&netboxURL, "netbox-url", "",
+		"Base URL of a NetBox instance providing machine addresses. NetBox is not used when empty.")
+	flag.StringVar(&netboxTokenSecret, "netbox-token-secret", "",
+		"Secret (namespace/name) with the NetBox API token in its token key.")
+	flag.DurationVar(&netboxCacheTTL, "netbox-cache-ttl", 5*time.Minute,
+		"How long a NetBox lookup is reused before NetBox is queried again.")
+	flag.StringVar(&addressInventoryRef, "address-inventory", "",
+		"ConfigMap or Secret (configmap/namespace/name or secret/namespace/name) mapping machine names, hostnames or MAC addresses to addresses. Disabled when empty.")
+	flag.StringVar(&addressInventoryPrecedence, "address-inventory-precedence", controller.AddressInventoryPrecedenceAnnotations,
+		"Which source wins when the annotations and the address inventory provide the same address type, one of annotations or inventory.")
+	flag.BoolVar(&remediation, "remediation", false,
+		"Remediate machines for LinkerRemediations created by MachineHealthChecks, requires the LinkerRemediation CRDs")
+	flag.DurationVar(&remediationReprovisionTimeout, "remediation-reprovision-timeout", time.Hour,
+		"How long a Reprovision remediation waits for the machine to be linked to a new node before it fails.")
+	flag.StringVar(&registrationAddr, "registration-bind-address", "",
+		"The address the host registration server binds to. Disabled when empty.")
+	flag.StringVar(&registrationCert, "registration-tls-cert", "",
+		"Certificate file served by the host registration server. Required unless --registration-insecure is set.")
+	flag.StringVar(&registrationKey, "registration-tls-key", "",
+		"Key file of --registration-tls-cert.")
+	flag.BoolVar(&registrationInsecure, "registration-insecure", false,
+		"Serve host registrations over plain HTTP, sending the tokens in the clear.")
+	flag.StringVar(&registrationSecretNamespace, "registration-secret-namespace", "machine-node-linker",
+		"Namespace of the registration token Secrets.")
+	flag.DurationVar(&provisionTimeout, "provision-timeout", 5*time.Minute,
+		"How long a single run of the provision command or request to the provisioning service may take.")
+	flag.IntVar(&provisionRetries, "provision-retries", 2,
+		"How many times a failed provision command is retried before the failure is reported.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -74,8 +203,53 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if useActuator {
+		// These features need the reconciler of this operator, which does not run with the actuator
+		unsupported := map[string]bool{
+			"delete-nodes": true, "drain-timeout": true, "guard-control-plane": true, "set-node-provider-id": true,
+			"node-replacement-policy": true, "verify-replaced-node-addresses": true, "heartbeat-leases": true,
+			"heartbeat-timeout": true, "bmc-poll-interval": true, "watch-baremetalhosts": true, "netbox-url": true,
+			"netbox-token-secret": true, "netbox-cache-ttl": true, "address-inventory": true,
+			"address-inventory-precedence": true, "provision-reconcile": true,
+		}
+		flag.Visit(func(f *flag.Flag) {
+			if unsupported[f.Name] {
+				setupLog.Error(fmt.Errorf("--%s is not supported with --use-actuator", f.Name), "invalid flag", "flag", f.Name)
+				os.Exit(1)
+			}
+		})
+	}
+
+	var addressInventory *controller.AddressInventory
+	cacheOptions := cache.Options{ByObject: map[client.Object]cache.ByObject{}}
+	if heartbeatLeases {
+		// Only cache the heartbeat Leases, not every Lease in the cluster
+		cacheOptions.ByObject[&coordinationv1.Lease{}] = cache.ByObject{
+			Namespaces: map[string]cache.Config{controller.HeartbeatNamespace: {}},
+		}
+	}
+	if addressInventoryRef != "" {
+		var err error
+		if addressInventory, err = controller.ParseAddressInventory(addressInventoryRef); err != nil {
+			setupLog.Error(err, "invalid flag", "flag", "address-inventory")
+			os.Exit(1)
+		}
+		// Only cache the inventory, not every ConfigMap or Secret in the cluster
+		cacheOptions.ByObject[addressInventory.Object()] = cache.ByObject{
+			Namespaces: map[string]cache.Config{addressInventory.Namespace: {}},
+			Field:      fields.OneTermEqualSelector("metadata.name", addressInventory.Name),
+		}
+	}
+	switch addressInventoryPrecedence {
+	case controller.AddressInventoryPrecedenceAnnotations, controller.AddressInventoryPrecedenceInventory:
+	default:
+		setupLog.Error(fmt.Errorf("unknown precedence %q", addressInventoryPrecedence), "invalid flag", "flag", "address-inventory-precedence")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
@@ -99,11 +273,114 @@ func main() {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+	if err := controller.IndexMachineFields(context.Background(), mgr); err != nil {
+		setupLog.Error(err, "unable to index machines")
+		os.Exit(1)
+	}
 
-	if err = (&controller.MachineReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	machineReconciler := &controller.MachineReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		SetNodeProviderID: setNodeProviderID,
+		DeleteNodes:       deleteNodes,
+		DrainTimeout:      drainTimeout,
+		GuardControlPlane: guardControlPlane,
+		HostPools:         hostPools,
+
+		NodeReplacementPolicy:       nodeReplacementPolicy,
+		VerifyReplacedNodeAddresses: verifyReplacedNodeAddresses,
+		HeartbeatLeases:             heartbeatLeases,
+		HeartbeatTimeout:            heartbeatTimeout,
+		BMCPollInterval:             bmcPollInterval,
+		BareMetalHosts:              bareMetalHosts,
+		NetBoxURL:                   netboxURL,
+		NetBoxCacheTTL:              netboxCacheTTL,
+		AddressInventory:            addressInventory,
+		AddressInventoryPrecedence:  addressInventoryPrecedence,
+	}
+	switch nodeReplacementPolicy {
+	case controller.NodeReplacementAccept, controller.NodeReplacementFail, controller.NodeReplacementApprove:
+	default:
+		setupLog.Error(fmt.Errorf("unknown policy %q", nodeReplacementPolicy), "invalid flag", "flag", "node-replacement-policy")
+		os.Exit(1)
+	}
+	if netboxTokenSecret != "" {
+		namespace, name, ok := strings.Cut(netboxTokenSecret, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(errors.New("expected namespace/name"), "invalid flag", "flag", "netbox-token-secret")
+			os.Exit(1)
+		}
+		machineReconciler.NetBoxTokenSecret = apitypes.NamespacedName{Namespace: namespace, Name: name}
+	}
+	if providerIDTemplate != "" {
+		if machineReconciler.ProviderIDTemplate, err = controller.ParseProviderIDTemplate(providerIDTemplate); err != nil {
+			setupLog.Error(err, "invalid flag", "flag", "provider-id-template")
+			os.Exit(1)
+		}
+	}
+	provisioners := 0
+	for _, value := range []string{provisionCommand, provisionJobTemplate, provisionURL} {
+		if value != "" {
+			provisioners++
+		}
+	}
+	switch {
+	case provisioners > 1:
+		setupLog.Error(errors.New("only one provisioner may be configured"), "invalid flag", "flag", "provision-command")
+		os.Exit(1)
+	case provisionCommand != "":
+		machineReconciler.Provisioner = &provision.ExecProvisioner{
+			Command: provisionCommand,
+			Timeout: provisionTimeout,
+			Retries: provisionRetries,
+			Backoff: 5 * time.Second,
+		}
+	case provisionJobTemplate != "":
+		template, err := provision.LoadJobTemplate(provisionJobTemplate)
+		if err != nil {
+			setupLog.Error(err, "invalid flag", "flag", "provision-job-template")
+			os.Exit(1)
+		}
+		kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to build kube client")
+			os.Exit(1)
+		}
+		machineReconciler.KubeClient = kubeClient
+		machineReconciler.Provisioner = &provision.JobProvisioner{
+			KubeClient: kubeClient,
+			Template:   template,
+			Timeout:    provisionTimeout,
+			Retries:    provisionRetries,
+		}
+	case provisionURL != "":
+		kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to build kube client")
+			os.Exit(1)
+		}
+		httpProvisioner := &provision.HTTPProvisioner{
+			URL:             provisionURL,
+			KubeClient:      kubeClient,
+			Timeout:         provisionTimeout,
+			ReconcileAction: provisionReconcile,
+		}
+		if provisionSecret != "" {
+			namespace, name, ok := strings.Cut(provisionSecret, "/")
+			if !ok || namespace == "" || name == "" {
+				setupLog.Error(errors.New("expected namespace/name"), "invalid flag", "flag", "provision-secret")
+				os.Exit(1)
+			}
+			httpProvisioner.SecretNamespace, httpProvisioner.SecretName = namespace, name
+		}
+		machineReconciler.Provisioner = httpProvisioner
+	}
+	if useActuator {
+		err = machineReconciler.SetupActuatorWithManager(mgr)
+	} else {
+		err = machineReconciler.SetupWithManager(mgr)
+	}
+	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Machine")
 		os.Exit(1)
 	}
@@ -114,6 +391,78 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
 	}
+	if hostPools {
+		if err = (&controller.HostPoolReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "HostPool")
+			os.Exit(1)
+		}
+	}
+	if machineSetCapacity {
+		if err = (&controller.MachineSetReconciler{
+			Client:    mgr.GetClient(),
+			Scheme:    mgr.GetScheme(),
+			HostPools: hostPools,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "MachineSet")
+			os.Exit(1)
+		}
+	}
+	if adoptNodes {
+		selector, err := labels.Parse(adoptNodeSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid flag", "flag", "adopt-node-selector")
+			os.Exit(1)
+		}
+		if err = (&controller.NodeAdoptReconciler{
+			Client:         mgr.GetClient(),
+			Scheme:         mgr.GetScheme(),
+			NodeSelector:   selector,
+			Namespace:      adoptNamespace,
+			MachineSetName: adoptMachineSet,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NodeAdopt")
+			os.Exit(1)
+		}
+	}
+	if remediation {
+		if err = (&controller.LinkerRemediationReconciler{
+			Client:             mgr.GetClient(),
+			Scheme:             mgr.GetScheme(),
+			Machines:           machineReconciler,
+			ReprovisionTimeout: remediationReprovisionTimeout,
+			DisableReprovision: useActuator,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "LinkerRemediation")
+			os.Exit(1)
+		}
+	}
+	if registrationAddr != "" {
+		if (registrationCert == "" || registrationKey == "") && !registrationInsecure {
+			setupLog.Error(errors.New("--registration-tls-cert and --registration-tls-key are required unless --registration-insecure is set"),
+				"invalid flag", "flag", "registration-bind-address")
+			os.Exit(1)
+		}
+		kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to build kube client")
+			os.Exit(1)
+		}
+		if err = mgr.Add(&controller.RegistrationServer{
+			Client:          mgr.GetClient(),
+			KubeClient:      kubeClient,
+			SecretNamespace: registrationSecretNamespace,
+			Addr:            registrationAddr,
+			CertFile:        registrationCert,
+			KeyFile:         registrationKey,
+			Insecure:        registrationInsecure,
+		}); err != nil {
+			setupLog.Error(err, "unable to add registration server")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	// if err = nodelink.Add(mgr, nil); err != nil {
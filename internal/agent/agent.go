@@ -0,0 +1,261 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+// Package agent discovers the addresses of the host it runs on and publishes them
+// to the annotations of the matching Machine
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/annotations"
+)
+
+// Interfaces skipped unless listed explicitly, these carry pod and tunnel traffic rather than the host address
+var DefaultExcludeInterfaces = []string{"veth", "cni", "flannel", "docker", "genev", "vxlan", "tun", "virbr", "ovn-k8s", "ovs-system"}
+
+// ErrMachineNotFound is returned when no Machine matches the host
+var ErrMachineNotFound = errors.New("no machine matches this host")
+
+// Interface of the host as seen by the agent
+type Interface struct {
+	Name     string
+	MAC      string
+	Addrs    []net.IP
+	Up       bool
+	Loopback bool
+}
+
+// HostReport is what the agent publishes to its machine
+type HostReport struct {
+	Hostname     string
+	InternalIPs  []string
+	MACAddresses []string
+}
+
+// Annotations of the machine carrying the report
+func (rep *HostReport) Annotations() map[string]string {
+	values := map[string]string{}
+	if rep.Hostname != "" {
+		values[annotations.Key(annotations.Hostname)] = rep.Hostname
+	}
+	if len(rep.InternalIPs) > 0 {
+		values[annotations.Key(annotations.InternalIP)] = rep.InternalIPs[0]
+	}
+	if len(rep.MACAddresses) > 0 {
+		values[annotations.Key(annotations.MACAddress)] = strings.Join(rep.MACAddresses, ",")
+	}
+	return values
+}
+
+// Discover the hostname, addresses and MAC addresses of the host
+// Interfaces are limited to names starting with one of include, when given, and never start with one of exclude
+func Discover(include, exclude []string) (*HostReport, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get hostname: %w", err)
+	}
+	netIfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list interfaces: %w", err)
+	}
+	var ifaces []Interface
+	for _, ni := range netIfaces {
+		iface := Interface{
+			Name:     ni.Name,
+			MAC:      ni.HardwareAddr.String(),
+			Up:       ni.Flags&net.FlagUp != 0,
+			Loopback: ni.Flags&net.FlagLoopback != 0,
+		}
+		addrs, err := ni.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list addresses of %s: %w", ni.Name, err)
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				iface.Addrs = append(iface.Addrs, ipNet.IP)
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return collect(hostname, ifaces, include, exclude), nil
+}
+
+// Build the report from the interfaces, IPv4 addresses are listed before IPv6 ones
+func collect(hostname string, ifaces []Interface, include, exclude []string) *HostReport {
+	rep := &HostReport{Hostname: hostname}
+	var v4, v6 []string
+	macs := map[string]bool{}
+	for _, iface := range ifaces {
+		if !iface.Up || iface.Loopback || !selected(iface.Name, include, exclude) {
+			continue
+		}
+		if iface.MAC != "" {
+			macs[strings.ToLower(iface.MAC)] = true
+		}
+		for _, ip := range iface.Addrs {
+			if !ip.IsGlobalUnicast() {
+				continue
+			}
+			if ip.To4() != nil {
+				v4 = append(v4, ip.String())
+			} else {
+				v6 = append(v6, ip.String())
+			}
+		}
+	}
+	rep.InternalIPs = append(v4, v6...)
+	for mac := range macs {
+		rep.MACAddresses = append(rep.MACAddresses, mac)
+	}
+	sort.Strings(rep.MACAddresses)
+	return rep
+}
+
+func selected(name string, include, exclude []string) bool {
+	for _, prefix := range exclude {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, prefix := range include {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Agent publishes reports to a Machine
+type Agent struct {
+	Client client.Client
+	// Namespace of the machines
+	Namespace string
+	// Name of the machine, found from the report when empty
+	MachineName string
+	// Node the agent runs on, when set only the machine linked to it or naming it is used
+	NodeName string
+}
+
+// Find the machine of the host: the configured one, the machine of the node the agent runs on,
+// or the only machine whose MAC addresses, node-name or hostname annotation, or name match the report
+func (a *Agent) FindMachine(ctx context.Context, rep *HostReport) (*machinev1.Machine, error) {
+	if a.MachineName != "" {
+		m := &machinev1.Machine{}
+		if err := a.Client.Get(ctx, apitypes.NamespacedName{Name: a.MachineName, Namespace: a.Namespace}, m); err != nil {
+			return nil, fmt.Errorf("unable to get machine: %w", err)
+		}
+		return m, nil
+	}
+
+	machines := &machinev1.MachineList{}
+	if err := a.Client.List(ctx, machines, client.InNamespace(a.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list machines: %w", err)
+	}
+	var found []*machinev1.Machine
+	for i := range machines.Items {
+		if a.NodeName != "" {
+			if ofNode(&machines.Items[i], a.NodeName) {
+				found = append(found, &machines.Items[i])
+			}
+			continue
+		}
+		if matches(&machines.Items[i], rep) {
+			found = append(found, &machines.Items[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, ErrMachineNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("machines %s and %s both match this host", found[0].Name, found[1].Name)
+	}
+}
+
+// Machine is linked to the node, or names it and is not linked yet
+// These are the only machines the admission policy of the agent DaemonSet lets it change
+func ofNode(m *machinev1.Machine, nodeName string) bool {
+	if m.Status.NodeRef != nil {
+		return m.Status.NodeRef.Name == nodeName
+	}
+	return m.Annotations[annotations.Key(annotations.NodeName)] == nodeName
+}
+
+func matches(m *machinev1.Machine, rep *HostReport) bool {
+	if m.Name == rep.Hostname ||
+		m.Annotations[annotations.Key(annotations.NodeName)] == rep.Hostname ||
+		m.Annotations[annotations.Key(annotations.Hostname)] == rep.Hostname {
+		return true
+	}
+	for _, mac := range strings.Split(m.Annotations[annotations.Key(annotations.MACAddress)], ",") {
+		if hw, err := net.ParseMAC(strings.TrimSpace(mac)); err == nil {
+			for _, reported := range rep.MACAddresses {
+				if hw.String() == reported {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Write the report to the annotations of the machine
+// Returns whether the machine was changed
+func (a *Agent) Publish(ctx context.Context, m *machinev1.Machine, rep *HostReport) (bool, error) {
+	patch := client.MergeFrom(m.DeepCopy())
+	changed := false
+	for key, value := range rep.Annotations() {
+		if m.Annotations[key] == value {
+			continue
+		}
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[key] = value
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	if err := a.Client.Patch(ctx, m, patch); err != nil {
+		return false, fmt.Errorf("unable to patch machine: %w", err)
+	}
+	return true, nil
+}
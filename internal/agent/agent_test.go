@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Agent", func() {
+
+	const MachineNamespace = "openshift-machine-api"
+
+	var ctx = context.Background()
+
+	ifaces := []Interface{
+		{Name: "lo", Addrs: []net.IP{net.ParseIP("127.0.0.1")}, Up: true, Loopback: true},
+		{Name: "eno1", MAC: "52:54:00:AA:BB:01", Addrs: []net.IP{net.ParseIP("fd00::10"), net.ParseIP("10.0.0.10"), net.ParseIP("fe80::1")}, Up: true},
+		{Name: "eno2", MAC: "52:54:00:aa:bb:02", Up: false},
+		{Name: "veth1234", MAC: "52:54:00:aa:bb:03", Addrs: []net.IP{net.ParseIP("10.128.0.1")}, Up: true},
+	}
+
+	newAgent := func(objs ...client.Object) *Agent {
+		return &Agent{
+			Client:    fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objs...).Build(),
+			Namespace: MachineNamespace,
+		}
+	}
+
+	It("Should report addresses of the selected interfaces", func() {
+		rep := collect("worker-0", ifaces, nil, DefaultExcludeInterfaces)
+		Expect(rep.InternalIPs).Should(Equal([]string{"10.0.0.10", "fd00::10"}))
+		Expect(rep.MACAddresses).Should(Equal([]string{"52:54:00:aa:bb:01"}))
+		Expect(rep.Annotations()).Should(Equal(map[string]string{
+			"machine-node-linker.github.com/hostname":    "worker-0",
+			"machine-node-linker.github.com/internal-ip": "10.0.0.10",
+			"machine-node-linker.github.com/mac-address": "52:54:00:aa:bb:01",
+		}))
+
+		rep = collect("worker-0", ifaces, []string{"veth"}, nil)
+		Expect(rep.InternalIPs).Should(Equal([]string{"10.128.0.1"}))
+	})
+
+	It("Should find the machine by MAC address and annotate it", func() {
+		a := newAgent(
+			&machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: MachineNamespace}},
+			&machinev1.Machine{ObjectMeta: metav1.ObjectMeta{
+				Name:        "worker-abcde",
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{"machine-node-linker.github.com/mac-address": "52-54-00-AA-BB-01"},
+			}},
+		)
+		rep := collect("worker-0", ifaces, nil, DefaultExcludeInterfaces)
+
+		m, err := a.FindMachine(ctx, rep)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(m.Name).Should(Equal("worker-abcde"))
+
+		changed, err := a.Publish(ctx, m, rep)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(changed).Should(BeTrue())
+
+		updated := &machinev1.Machine{}
+		Expect(a.Client.Get(ctx, types.NamespacedName{Name: "worker-abcde", Namespace: MachineNamespace}, updated)).Should(Succeed())
+		Expect(updated.Annotations).Should(HaveKeyWithValue("machine-node-linker.github.com/internal-ip", "10.0.0.10"))
+
+		changed, err = a.Publish(ctx, updated, rep)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(changed).Should(BeFalse())
+	})
+
+	It("Should only use the machine of its node", func() {
+		a := newAgent(
+			&machinev1.Machine{ObjectMeta: metav1.ObjectMeta{
+				Name:        "worker-abcde",
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{"machine-node-linker.github.com/mac-address": "52:54:00:aa:bb:01"},
+			}},
+			&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker-fghij", Namespace: MachineNamespace},
+				Status:     machinev1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: "worker-0"}},
+			},
+		)
+		a.NodeName = "worker-0"
+		m, err := a.FindMachine(ctx, collect("worker-0", ifaces, nil, DefaultExcludeInterfaces))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(m.Name).Should(Equal("worker-fghij"))
+
+		a.NodeName = "worker-1"
+		_, err = a.FindMachine(ctx, collect("worker-0", ifaces, nil, DefaultExcludeInterfaces))
+		Expect(err).Should(MatchError(ErrMachineNotFound))
+	})
+
+	It("Should not guess when no machine matches", func() {
+		a := newAgent(&machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: MachineNamespace}})
+		_, err := a.FindMachine(ctx, collect("worker-0", ifaces, nil, nil))
+		Expect(err).Should(MatchError(ErrMachineNotFound))
+	})
+})
@@ -0,0 +1,45 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+// Package annotations holds the machine-node-linker.github.com/ annotation keys shared by the
+// controllers, the node agent and linkerctl
+package annotations
+
+// Prefix of every annotation of this project
+const Base = "machine-node-linker.github.com"
+
+// Annotations holding the addresses and identity of the host of a machine
+const (
+	InternalIP  = "internal-ip"
+	InternalDNS = "internal-dns"
+	Hostname    = "hostname"
+	MACAddress  = "mac-address"
+	NodeName    = "node-name"
+)
+
+// Key returns the full annotation key for name
+func Key(name string) string {
+	return Base + "/" + name
+}
@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+// Package bmc talks to baseboard management controllers over Redfish
+package bmc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Power states reported by Redfish
+	PowerOn          = "On"
+	PowerOff         = "Off"
+	PowerPoweringOn  = "PoweringOn"
+	PowerPoweringOff = "PoweringOff"
+
+	// Reset types sent to Redfish
+	ResetOn           = "On"
+	ResetForceOff     = "ForceOff"
+	ResetForceRestart = "ForceRestart"
+
+	systemsPath = "/redfish/v1/Systems"
+)
+
+// Redfish is a client for the computer system behind a BMC
+type Redfish struct {
+	// Base URL of the BMC and, when the path is set, of the system
+	Endpoint *url.URL
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+}
+
+// NewRedfish builds a client from a BMC address
+// redfish:// and redfish+https:// use https, redfish+http:// uses http, like metal3 BMC addresses
+// A path in the address selects the system, otherwise the first system of the BMC is used
+func NewRedfish(address, username, password string, insecure bool, timeout time.Duration) (*Redfish, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BMC address %q: %w", address, err)
+	}
+	switch u.Scheme {
+	case "redfish", "redfish+https", "https":
+		u.Scheme = "https"
+	case "redfish+http", "http":
+		u.Scheme = "http"
+	default:
+		return nil, fmt.Errorf("unsupported BMC address scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid BMC address %q: missing host", address)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	return &Redfish{
+		Endpoint:   u,
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+// PowerState of the system
+func (r *Redfish) PowerState(ctx context.Context) (string, error) {
+	path, err := r.systemPath(ctx)
+	if err != nil {
+		return "", err
+	}
+	system := struct {
+		PowerState string `json:"PowerState"`
+	}{}
+	if err := r.do(ctx, http.MethodGet, path, nil, &system); err != nil {
+		return "", err
+	}
+	return system.PowerState, nil
+}
+
+// Reset the system with a Redfish ResetType
+func (r *Redfish) Reset(ctx context.Context, resetType string) error {
+	path, err := r.systemPath(ctx)
+	if err != nil {
+		return err
+	}
+	body := map[string]string{"ResetType": resetType}
+	return r.do(ctx, http.MethodPost, path+"/Actions/ComputerSystem.Reset", body, nil)
+}
+
+// Path of the system, from the address or the first member of the systems collection
+func (r *Redfish) systemPath(ctx context.Context) (string, error) {
+	if r.Endpoint.Path != "" {
+		return r.Endpoint.Path, nil
+	}
+	systems := struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		} `json:"Members"`
+	}{}
+	if err := r.do(ctx, http.MethodGet, systemsPath, nil, &systems); err != nil {
+		return "", err
+	}
+	if len(systems.Members) == 0 || systems.Members[0].ID == "" {
+		return "", errors.New("BMC reports no systems")
+	}
+	return systems.Members[0].ID, nil
+}
+
+func (r *Redfish) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("unable to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	u := *r.Endpoint
+	u.Path = path
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("redfish %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("redfish %s %s: unable to read response: %w", method, path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("redfish %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("redfish %s %s: unable to decode response: %w", method, path, err)
+	}
+	return nil
+}
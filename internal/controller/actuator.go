@@ -0,0 +1,259 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+// manualActuator implements the machine-api-operator Actuator for machines whose
+// instances are provisioned outside the cluster and described by annotations
+// The machine-api-operator machine controller owns phase, finalizers, drain and node deletion
+type manualActuator struct {
+	r *MachineReconciler
+}
+
+var _ machine.Actuator = &manualActuator{}
+
+// Actuator returns a machine-api-operator Actuator sharing the configuration of the reconciler
+func (r *MachineReconciler) Actuator() machine.Actuator {
+	return &manualActuator{r: r}
+}
+
+// SetupActuatorWithManager runs the machine-api-operator machine controller with the manual actuator
+// Used in place of SetupWithManager, the two must not run together
+func (r *MachineReconciler) SetupActuatorWithManager(mgr ctrl.Manager) error {
+	if err := r.setupClients(mgr); err != nil {
+		return err
+	}
+	r.bareMetalHostReader = mgr.GetCache()
+	return machine.AddWithActuator(&managedMachineManager{Manager: mgr, client: &managedMachineClient{Client: mgr.GetClient(), r: r}}, r.Actuator())
+}
+
+// managedMachineManager hands the machine-api-operator controllers a client that hides unmanaged machines
+type managedMachineManager struct {
+	ctrl.Manager
+	client client.Client
+}
+
+func (m *managedMachineManager) GetClient() client.Client {
+	return m.client
+}
+
+// managedMachineClient reports machines this operator does not manage as not found,
+// so the machine and drain controllers leave them to their own provider
+type managedMachineClient struct {
+	client.Client
+	r *MachineReconciler
+}
+
+func (c *managedMachineClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.Client.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	m, ok := obj.(*machinev1.Machine)
+	if !ok {
+		return nil
+	}
+	managed, err := c.r.managesMachine(ctx, m)
+	if err != nil {
+		return err
+	}
+	if !managed {
+		return apierrors.NewNotFound(machinev1.Resource("machines"), key.Name)
+	}
+	return nil
+}
+
+// Machine is linker managed or waits for a host from a HostPool
+func (r *MachineReconciler) managesMachine(ctx context.Context, m *machinev1.Machine) (bool, error) {
+	if isLinkerManaged(m) {
+		return true, nil
+	}
+	if !r.HostPools {
+		return false, nil
+	}
+	pool, err := r.hostPoolForMachine(ctx, m)
+	return pool != nil, err
+}
+
+// Create claims a host from a matching HostPool and runs the create hook when
+// configured, otherwise the instance is created by an outside process
+// The machine stays in Provisioning until Exists finds it
+func (a *manualActuator) Create(ctx context.Context, m *machinev1.Machine) error {
+	if res, err := a.r.reconcileHostClaim(ctx, m); err != nil {
+		return machine.CreateMachine("%v", err)
+	} else if res.RequeueAfter > 0 {
+		return &machine.RequeueAfterError{RequeueAfter: res.RequeueAfter}
+	}
+	if a.r.Provisioner == nil || isConditionTrue(m, conditionInstanceProvisioned) {
+		log.FromContext(ctx).Info("Waiting for instance to be provided", "Machine", m.GetName())
+		return nil
+	}
+	res, err := a.provision(ctx, m, provision.ActionCreate, conditionInstanceProvisioned)
+	if err != nil {
+		return err
+	}
+	if applyProvisionResult(m, res) {
+		if err := a.r.Client.Update(ctx, m); err != nil {
+			return fmt.Errorf("unable to update machine: %w", err)
+		}
+	}
+	base := m.DeepCopy()
+	conditions.MarkTrue(m, conditionInstanceProvisioned)
+	if err := a.r.Client.Status().Patch(ctx, m, client.MergeFrom(base)); err != nil {
+		return fmt.Errorf("unable to patch machine status: %w", err)
+	}
+	return nil
+}
+
+// Delete runs the delete hook when a provisioner is configured and releases the claimed host
+// The machine controller deletes the node afterwards
+func (a *manualActuator) Delete(ctx context.Context, m *machinev1.Machine) error {
+	if a.r.Provisioner != nil {
+		if _, err := a.provision(ctx, m, provision.ActionDelete, conditionInstanceDeprovisioned); err != nil {
+			return err
+		}
+	}
+	if err := a.r.releaseHost(ctx, m); err != nil {
+		return machine.DeleteMachine("%v", err)
+	}
+	a.r.recordEvent(m, corev1.EventTypeNormal, "Deleted", "Deleted machine %q", m.GetName())
+	return nil
+}
+
+// Run a provisioner action, translating the result into machine controller errors
+// The condition is patched on failure and progress only, Update reports the instance afterwards
+func (a *manualActuator) provision(ctx context.Context, m *machinev1.Machine, action provision.Action, conditionType machinev1.ConditionType) (*provision.Result, error) {
+	base := m.DeepCopy()
+	res, err := a.r.runProvisioner(ctx, m, action, conditionType)
+	if err == nil {
+		return res, nil
+	}
+	if pErr := a.r.Client.Status().Patch(ctx, m, client.MergeFrom(base)); pErr != nil {
+		return nil, fmt.Errorf("unable to patch machine status: %w", pErr)
+	}
+	if errors.Is(err, provision.ErrInProgress) {
+		return nil, &machine.RequeueAfterError{RequeueAfter: provisionRequeueAfter}
+	}
+	if action == provision.ActionDelete {
+		return nil, machine.DeleteMachine("%v", err)
+	}
+	return nil, machine.CreateMachine("%v", err)
+}
+
+// Exists reports an instance once any source describes it
+func (a *manualActuator) Exists(ctx context.Context, m *machinev1.Machine) (bool, error) {
+	if !m.DeletionTimestamp.IsZero() {
+		return false, nil
+	}
+	if m.Spec.ProviderID != nil && *m.Spec.ProviderID != "" {
+		return true, nil
+	}
+	if _, ok := m.Annotations[getAnnotationKey(ProviderIDAnnotation)]; ok {
+		return true, nil
+	}
+	if _, ok := m.Annotations[getAnnotationKey(ProviderStateAnnotation)]; ok {
+		return true, nil
+	}
+	bmh, err := a.r.bareMetalHostFor(ctx, m)
+	if err != nil && !errors.Is(err, errBareMetalHostNotFound) {
+		return false, err
+	}
+	_, sources, err := a.r.desiredAddresses(ctx, m, bmh)
+	if err != nil {
+		return false, err
+	}
+	return len(sources) > 0, nil
+}
+
+// Update writes providerID, addresses and providerStatus to the machine
+// The machine controller does not persist changes made by the actuator, so they are patched here
+func (a *manualActuator) Update(ctx context.Context, m *machinev1.Machine) error {
+	logger := log.FromContext(ctx)
+
+	if m.Spec.ProviderID == nil || *m.Spec.ProviderID == "" {
+		providerID, err := a.r.providerIDForMachine(m)
+		if err != nil {
+			return machine.UpdateMachine("unable to determine providerID: %v", err)
+		}
+		if providerID != "" {
+			patch := client.MergeFrom(m.DeepCopy())
+			m.Spec.ProviderID = &providerID
+			logger.Info("Setting providerID", "ProviderID", providerID)
+			if err := a.r.Client.Patch(ctx, m, patch); err != nil {
+				return fmt.Errorf("unable to patch machine: %w", err)
+			}
+		}
+	}
+
+	base := m.DeepCopy()
+	bmh, bmhErr := a.r.bareMetalHostFor(ctx, m)
+	if bmhErr != nil && !errors.Is(bmhErr, errBareMetalHostNotFound) {
+		return machine.UpdateMachine("%v", bmhErr)
+	}
+	addresses, addrSources, err := a.r.desiredAddresses(ctx, m, bmh)
+	if err != nil {
+		return machine.UpdateMachine("%v", err)
+	}
+	m.Status.Addresses = addresses
+
+	heartbeat, _, err := a.r.heartbeatState(ctx, m)
+	if err != nil {
+		return machine.UpdateMachine("%v", err)
+	}
+	newPs, err := a.r.desiredProviderStatus(ctx, m, addrSources, heartbeat, bmh, bmhErr)
+	if err != nil {
+		return machine.UpdateMachine("%v", err)
+	}
+	if newPs != nil {
+		if m.Status.ProviderStatus, err = newPs.toRawExtension(); err != nil {
+			return fmt.Errorf("unable to create RawExtension: %w", err)
+		}
+	}
+
+	if !reflect.DeepEqual(base.Status, m.Status) {
+		logger.Info("New Status", "Status", m.Status)
+		if err := a.r.Client.Status().Patch(ctx, m, client.MergeFrom(base)); err != nil {
+			return fmt.Errorf("unable to patch machine status: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Manual actuator", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		MachineIP        = "1.2.3.4"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+			},
+		}
+	})
+
+	newReconciler := func(objs ...client.Object) {
+		r = newFakeMachineReconciler(append(objs, rawMachine)...)
+	}
+
+	It("Should not find an instance for an unannotated machine", func() {
+		newReconciler()
+		exists, err := r.Actuator().Exists(ctx, rawMachine)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(exists).Should(BeFalse())
+	})
+
+	It("Should find the instance and update the machine from annotations", func() {
+		rawMachine.Annotations = map[string]string{
+			getAnnotationKey(InternalIPAnnotation):    MachineIP,
+			getAnnotationKey(ProviderIDAnnotation):    "manual:///test-machine",
+			getAnnotationKey(ProviderStateAnnotation): "running",
+		}
+		newReconciler()
+
+		exists, err := r.Actuator().Exists(ctx, rawMachine)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(exists).Should(BeTrue())
+
+		Expect(r.Actuator().Update(ctx, rawMachine.DeepCopy())).Should(Succeed())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Spec.ProviderID).Should(HaveValue(Equal("manual:///test-machine")))
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: MachineIP}))
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ps.InstanceState).Should(HaveValue(Equal("running")))
+	})
+
+	It("Should hide unmanaged machines from the machine controller", func() {
+		newReconciler()
+		c := &managedMachineClient{Client: r.Client, r: r}
+		err := c.Get(ctx, lookupKey, &machinev1.Machine{})
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		m.Annotations = map[string]string{getAnnotationKey(ManagedAnnotation): ""}
+		Expect(r.Client.Update(ctx, m)).Should(Succeed())
+		Expect(c.Get(ctx, lookupKey, &machinev1.Machine{})).Should(Succeed())
+	})
+
+	It("Should report no instance once the machine is deleted", func() {
+		now := metav1.Now()
+		rawMachine.Finalizers = []string{machinev1.MachineFinalizer}
+		rawMachine.DeletionTimestamp = &now
+		rawMachine.Annotations = map[string]string{getAnnotationKey(InternalIPAnnotation): MachineIP}
+		newReconciler()
+
+		Expect(r.Actuator().Delete(ctx, rawMachine)).Should(Succeed())
+		exists, err := r.Actuator().Exists(ctx, rawMachine)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(exists).Should(BeFalse())
+	})
+	It("Should release the claimed host on delete", func() {
+		rawMachine.UID = "machine-uid"
+		rawMachine.Annotations = map[string]string{getAnnotationKey(HostAnnotation): "host-0"}
+		host := &inventoryv1alpha1.Host{
+			ObjectMeta: metav1.ObjectMeta{Name: "host-0", Namespace: MachineNamespace},
+			Status: inventoryv1alpha1.HostStatus{
+				State:      inventoryv1alpha1.HostStateClaimed,
+				MachineRef: &corev1.ObjectReference{Name: MachineName, Namespace: MachineNamespace, UID: rawMachine.UID},
+			},
+		}
+		newReconciler(host)
+
+		Expect(r.Actuator().Delete(ctx, rawMachine)).Should(Succeed())
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(host), host)).Should(Succeed())
+		Expect(host.Status.MachineRef).Should(BeNil())
+		Expect(host.Status.State).Should(Equal(inventoryv1alpha1.HostStateAvailable))
+	})
+})
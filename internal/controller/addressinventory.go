@@ -0,0 +1,239 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	AddressInventoryConfigMap = "configmap"
+	AddressInventorySecret    = "secret"
+
+	// Which source wins when the annotations and the address inventory provide the same address type
+	AddressInventoryPrecedenceAnnotations = "annotations"
+	AddressInventoryPrecedenceInventory   = "inventory"
+
+	addressSourceInventory = "inventory"
+)
+
+// ConfigMap or Secret mapping machine names, hostnames or MAC addresses to addresses
+type AddressInventory struct {
+	// configmap or secret
+	Kind string
+	apitypes.NamespacedName
+}
+
+// Parse an address inventory reference of the form configmap/namespace/name or secret/namespace/name
+func ParseAddressInventory(ref string) (*AddressInventory, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid address inventory %q: expected configmap/namespace/name or secret/namespace/name", ref)
+	}
+	kind := strings.ToLower(parts[0])
+	if kind != AddressInventoryConfigMap && kind != AddressInventorySecret {
+		return nil, fmt.Errorf("invalid address inventory %q: unknown kind %q", ref, parts[0])
+	}
+	return &AddressInventory{Kind: kind, NamespacedName: apitypes.NamespacedName{Namespace: parts[1], Name: parts[2]}}, nil
+}
+
+// Empty object of the inventory kind, for reads and watches
+func (i *AddressInventory) Object() client.Object {
+	if i.Kind == AddressInventorySecret {
+		return &corev1.Secret{}
+	}
+	return &corev1.ConfigMap{}
+}
+
+// Addresses of a single inventory entry
+// The hostname is also used as internal DNS name, like the hostname annotation
+type addressInventoryEntry struct {
+	InternalIP  string `json:"internalIP,omitempty"`
+	InternalDNS string `json:"internalDNS,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+}
+
+func (e *addressInventoryEntry) addresses() []corev1.NodeAddress {
+	var addr []corev1.NodeAddress
+	if e.InternalIP != "" {
+		addr = append(addr, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: e.InternalIP})
+	}
+	if e.Hostname != "" {
+		addr = append(addr,
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: e.Hostname},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: e.Hostname},
+		)
+	}
+	if e.InternalDNS != "" {
+		addr = append(addr, corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: e.InternalDNS})
+	}
+	return addr
+}
+
+// Inventory entries by key, with MAC address keys also indexed by their canonical form
+type addressInventoryIndex struct {
+	byKey map[string]string
+	byMAC map[string]string
+}
+
+// Index the data of the inventory ConfigMap or Secret
+// MAC address keys use dashes as separators, since keys cannot contain colons
+func newAddressInventoryIndex(o client.Object) *addressInventoryIndex {
+	idx := &addressInventoryIndex{byKey: map[string]string{}, byMAC: map[string]string{}}
+	add := func(key, value string) {
+		idx.byKey[key] = value
+		if hw, err := net.ParseMAC(key); err == nil {
+			idx.byMAC[hw.String()] = value
+		}
+	}
+	switch obj := o.(type) {
+	case *corev1.ConfigMap:
+		for k, v := range obj.Data {
+			add(k, v)
+		}
+	case *corev1.Secret:
+		for k, v := range obj.Data {
+			add(k, string(v))
+		}
+	}
+	return idx
+}
+
+// Keys a machine is looked up by, in order: machine name, hostname annotation, node name, then MAC addresses
+func addressInventoryKeys(m *machinev1.Machine) []string {
+	keys := []string{m.Name}
+	for _, key := range []string{m.Annotations[getAnnotationKey(HostnameAnnotation)], m.Annotations[getAnnotationKey(NodeNameAnnotation)]} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if m.Status.NodeRef != nil && m.Status.NodeRef.Name != "" {
+		keys = append(keys, m.Status.NodeRef.Name)
+	}
+	return keys
+}
+
+// The inventory entry of the machine and the key it was found by, empty when the machine is not in the inventory
+func (idx *addressInventoryIndex) lookup(m *machinev1.Machine) (string, string, bool) {
+	for _, key := range addressInventoryKeys(m) {
+		if value, ok := idx.byKey[key]; ok {
+			return key, value, true
+		}
+	}
+	for _, mac := range machineMACAddresses(m) {
+		if value, ok := idx.byMAC[mac]; ok {
+			return mac, value, true
+		}
+	}
+	return "", "", false
+}
+
+// Addresses of the machine from the address inventory, nil when no inventory is configured or the machine is not in it
+func (r *MachineReconciler) addressInventoryAddresses(ctx context.Context, m *machinev1.Machine) ([]corev1.NodeAddress, error) {
+	if r.AddressInventory == nil {
+		return nil, nil
+	}
+	o := r.AddressInventory.Object()
+	if err := r.Client.Get(ctx, r.AddressInventory.NamespacedName, o); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Info("address inventory not found", r.AddressInventory.Kind, r.AddressInventory.NamespacedName)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get address inventory: %w", err)
+	}
+	key, value, ok := newAddressInventoryIndex(o).lookup(m)
+	if !ok {
+		return nil, nil
+	}
+	entry := &addressInventoryEntry{}
+	if err := yaml.UnmarshalStrict([]byte(value), entry); err != nil {
+		return nil, fmt.Errorf("invalid address inventory entry %q: %w", key, err)
+	}
+	return entry.addresses(), nil
+}
+
+// The annotations and the address inventory in order of precedence
+func (r *MachineReconciler) annotationAndInventorySources(annotations, inventory []corev1.NodeAddress) []addressSource {
+	sources := []addressSource{
+		{addressSourceAnnotations, annotations},
+		{addressSourceInventory, inventory},
+	}
+	if r.AddressInventoryPrecedence == AddressInventoryPrecedenceInventory {
+		sources[0], sources[1] = sources[1], sources[0]
+	}
+	return sources
+}
+
+// Only the configured ConfigMap or Secret is watched
+func (r *MachineReconciler) isAddressInventory(o client.Object) bool {
+	return r.AddressInventory != nil && client.ObjectKeyFromObject(o) == r.AddressInventory.NamespacedName
+}
+
+func (r *MachineReconciler) addressInventoryPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(r.isAddressInventory)
+}
+
+// Map the address inventory to the machines it has an entry for and the machines that used it before
+// The latter pick up removed entries
+func (r *MachineReconciler) machinesForAddressInventory(ctx context.Context, o client.Object) []reconcile.Request {
+	machines := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, machines); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list machines")
+		return nil
+	}
+	idx := newAddressInventoryIndex(o)
+	var requests []reconcile.Request
+	for i := range machines.Items {
+		m := &machines.Items[i]
+		if _, _, ok := idx.lookup(m); ok || usedAddressInventory(m) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(m)})
+		}
+	}
+	return requests
+}
+
+// The providerStatus of the machine records addresses from the address inventory
+func usedAddressInventory(m *machinev1.Machine) bool {
+	ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(ps.AddressSources, addressSourceInventory)
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Address inventory", func() {
+
+	const (
+		MachineName        = "test-machine"
+		MachineNamespace   = "openshift-machine-api"
+		InventoryNamespace = "machine-node-linker"
+		InventoryName      = "addresses"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		rawMachine *machinev1.Machine
+		inventory  client.Object
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	configMap := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: InventoryName, Namespace: InventoryNamespace},
+			Data:       data,
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+			},
+		}
+		inventory = configMap(map[string]string{
+			MachineName: "internalIP: 10.0.0.5\nhostname: test-machine.example.com\n",
+		})
+	})
+
+	newReconciler := func(objs ...client.Object) {
+		kind := AddressInventoryConfigMap
+		if _, ok := inventory.(*corev1.Secret); ok {
+			kind = AddressInventorySecret
+		}
+		r = newFakeMachineReconciler(append(objs, rawMachine, inventory)...)
+		r.AddressInventory = &AddressInventory{
+			Kind:           kind,
+			NamespacedName: types.NamespacedName{Namespace: InventoryNamespace, Name: InventoryName},
+		}
+		r.AddressInventoryPrecedence = AddressInventoryPrecedenceAnnotations
+	}
+
+	getMachine := func() (*machinev1.Machine, *providerStatus) {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		return m, ps
+	}
+
+	It("Should set the addresses of the entry named after the machine", func() {
+		newReconciler()
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: "test-machine.example.com"},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: "test-machine.example.com"},
+		))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceInventory}))
+	})
+
+	It("Should find the entry of a MAC address in a Secret", func() {
+		rawMachine.Annotations = map[string]string{getAnnotationKey(MACAddressAnnotation): "AA:BB:CC:00:11:22"}
+		inventory = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: InventoryName, Namespace: InventoryNamespace},
+			Data: map[string][]byte{
+				"aa-bb-cc-00-11-22": []byte("internalIP: 10.0.0.9\n"),
+			},
+		}
+		newReconciler()
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m, _ := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.9"},
+		))
+	})
+
+	It("Should prefer the annotations and fill in the missing address types by default", func() {
+		rawMachine.Annotations = map[string]string{getAnnotationKey(InternalIPAnnotation): "192.168.1.5"}
+		newReconciler()
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.1.5"},
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: "test-machine.example.com"},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: "test-machine.example.com"},
+		))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceAnnotations, addressSourceInventory}))
+	})
+
+	It("Should prefer the inventory with inventory precedence", func() {
+		rawMachine.Annotations = map[string]string{getAnnotationKey(InternalIPAnnotation): "192.168.1.5"}
+		newReconciler()
+		r.AddressInventoryPrecedence = AddressInventoryPrecedenceInventory
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}))
+		Expect(m.Status.Addresses).ShouldNot(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.1.5"}))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceInventory}))
+	})
+
+	It("Should fail on an invalid entry", func() {
+		inventory = configMap(map[string]string{MachineName: "internalAddress: 10.0.0.5\n"})
+		newReconciler()
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().Should(HaveOccurred())
+	})
+
+	It("Should ignore a missing inventory", func() {
+		newReconciler()
+		Expect(r.Client.Delete(ctx, inventory)).Should(Succeed())
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m, _ := getMachine()
+		Expect(m.Status.Addresses).Should(BeEmpty())
+	})
+
+	It("Should map the inventory to the machines in it and the machines that used it", func() {
+		ps := newProviderStatus()
+		ps.setAddressSources([]string{addressSourceInventory})
+		raw, err := ps.toRawExtension()
+		Expect(err).ShouldNot(HaveOccurred())
+		removed := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "removed", Namespace: MachineNamespace},
+			Status:     machinev1.MachineStatus{ProviderStatus: raw},
+		}
+		byHostname := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "by-hostname",
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{getAnnotationKey(HostnameAnnotation): "node-3"},
+			},
+		}
+		other := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: MachineNamespace},
+		}
+		inventory = configMap(map[string]string{
+			MachineName: "internalIP: 10.0.0.5\n",
+			"node-3":    "internalIP: 10.0.0.3\n",
+		})
+		newReconciler(removed, byHostname, other)
+
+		Expect(r.machinesForAddressInventory(ctx, inventory)).Should(ConsistOf(
+			reconcile.Request{NamespacedName: lookupKey},
+			reconcile.Request{NamespacedName: client.ObjectKeyFromObject(removed)},
+			reconcile.Request{NamespacedName: client.ObjectKeyFromObject(byHostname)},
+		))
+		Expect(r.isAddressInventory(inventory)).Should(BeTrue())
+		Expect(r.isAddressInventory(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: InventoryNamespace}})).Should(BeFalse())
+	})
+
+	DescribeTable("Parsing the address inventory flag",
+		func(ref string, expected *AddressInventory) {
+			i, err := ParseAddressInventory(ref)
+			if expected == nil {
+				Expect(err).Should(HaveOccurred())
+				return
+			}
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(i).Should(Equal(expected))
+		},
+		Entry("configmap", "configmap/ns/name", &AddressInventory{Kind: AddressInventoryConfigMap, NamespacedName: types.NamespacedName{Namespace: "ns", Name: "name"}}),
+		Entry("secret", "Secret/ns/name", &AddressInventory{Kind: AddressInventorySecret, NamespacedName: types.NamespacedName{Namespace: "ns", Name: "name"}}),
+		Entry("missing namespace", "configmap/name", nil),
+		Entry("unknown kind", "pod/ns/name", nil),
+	)
+})
@@ -0,0 +1,281 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+const (
+	// Set on machines created for an orphan node, holds the node name
+	AdoptedNodeAnnotation = "adopted-node"
+	// Set on adopted machines until the replicas of their MachineSet have been raised, holds the MachineSet name
+	PendingReplicaAnnotation = "pending-replica"
+
+	// Set on nodes by the machine-api-operator nodelink controller, holds namespace/name of the machine
+	nodeMachineAnnotation = "machine.openshift.io/machine"
+
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+	machineTypeLabel    = "machine.openshift.io/cluster-api-machine-type"
+)
+
+// NodeAdoptReconciler creates machines for nodes that no machine is linked to
+type NodeAdoptReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Only nodes matching the selector are adopted
+	NodeSelector labels.Selector
+	// Namespace the machines are created in
+	Namespace string
+	// Optional MachineSet set as the controller of the machines
+	MachineSetName string
+
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machinesets,verbs=get;patch
+func (r *NodeAdoptReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, req.NamespacedName, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get node: %v", err)
+	}
+	if !n.DeletionTimestamp.IsZero() || !r.NodeSelector.Matches(labels.Set(n.Labels)) {
+		return ctrl.Result{}, nil
+	}
+
+	existing, err := r.findMachine(ctx, n)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if existing != nil {
+		return ctrl.Result{}, r.reconcilePendingReplica(ctx, n, existing)
+	}
+
+	var ms *machinev1.MachineSet
+	if r.MachineSetName != "" {
+		ms = &machinev1.MachineSet{}
+		if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: r.MachineSetName, Namespace: r.Namespace}, ms); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to get machineset: %w", err)
+		}
+	}
+
+	m := r.machineForNode(n, ms)
+	logger.Info("Adopting node", "Node", n.Name, "Machine", m.Name)
+	if err := r.Client.Create(ctx, m); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return ctrl.Result{}, r.checkExistingMachine(ctx, n, m)
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to create machine: %w", err)
+	}
+	r.recordEvent(n, corev1.EventTypeNormal, "Adopted", "Created machine %s/%s", m.Namespace, m.Name)
+	return ctrl.Result{}, r.reconcilePendingReplica(ctx, n, m)
+}
+
+// A machine with the name of the node exists, report it when it was not created for the node
+func (r *NodeAdoptReconciler) checkExistingMachine(ctx context.Context, n *corev1.Node, m *machinev1.Machine) error {
+	existing := &machinev1.Machine{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(m), existing); err != nil {
+		return fmt.Errorf("unable to get machine: %w", err)
+	}
+	if existing.Annotations[getAnnotationKey(AdoptedNodeAnnotation)] != n.Name {
+		r.recordEvent(n, corev1.EventTypeWarning, "AdoptConflict", "Machine %s/%s already exists and was not created for this node", m.Namespace, m.Name)
+	}
+	return nil
+}
+
+// Raise the replicas of the MachineSet of an adopted machine by one, then remove the pending-replica annotation
+// The machine is a replica of the MachineSet, without one more replica the MachineSet would scale it or another machine down
+// A failure leaves the annotation in place, so the next reconcile of the node tries again instead of skipping the existing machine
+func (r *NodeAdoptReconciler) reconcilePendingReplica(ctx context.Context, n *corev1.Node, m *machinev1.Machine) error {
+	name, ok := m.Annotations[getAnnotationKey(PendingReplicaAnnotation)]
+	if !ok {
+		return nil
+	}
+	ms := &machinev1.MachineSet{}
+	err := r.Client.Get(ctx, apitypes.NamespacedName{Name: name, Namespace: m.Namespace}, ms)
+	switch {
+	case err == nil:
+		if err := r.addReplica(ctx, ms); err != nil {
+			r.recordEvent(n, corev1.EventTypeWarning, "AdoptReplicasFailed", "Unable to add a replica to machineset %s/%s: %v", ms.Namespace, ms.Name, err)
+			return err
+		}
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("unable to get machineset: %w", err)
+	}
+
+	delete(m.Annotations, getAnnotationKey(PendingReplicaAnnotation))
+	if err := r.Client.Update(ctx, m); err != nil {
+		return fmt.Errorf("unable to update machine: %w", err)
+	}
+	return nil
+}
+
+// Raise the replicas of the MachineSet by one
+func (r *NodeAdoptReconciler) addReplica(ctx context.Context, ms *machinev1.MachineSet) error {
+	patch := client.MergeFromWithOptions(ms.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	replicas := int32(1)
+	if ms.Spec.Replicas != nil {
+		replicas = *ms.Spec.Replicas + 1
+	}
+	ms.Spec.Replicas = &replicas
+	if err := r.Client.Patch(ctx, ms, patch); err != nil {
+		return fmt.Errorf("unable to patch machineset: %w", err)
+	}
+	return nil
+}
+
+func (r *NodeAdoptReconciler) recordEvent(n *corev1.Node, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(n, eventType, reason, messageFmt, args...)
+	}
+}
+
+// Find the machine linked to the node, or created for it and waiting to be linked
+// Returns nil when there is none
+func (r *NodeAdoptReconciler) findMachine(ctx context.Context, n *corev1.Node) (*machinev1.Machine, error) {
+	if ref, ok := n.Annotations[nodeMachineAnnotation]; ok && ref != "" {
+		if namespace, name, found := strings.Cut(ref, "/"); found {
+			m := &machinev1.Machine{}
+			err := r.Client.Get(ctx, apitypes.NamespacedName{Name: name, Namespace: namespace}, m)
+			if err == nil {
+				return m, nil
+			}
+			if !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("unable to get machine: %w", err)
+			}
+		}
+	}
+
+	// Adopted machines carry the node-name annotation as well
+	lookups := map[string]string{
+		machineNodeRefIndex:  n.Name,
+		machineNodeNameIndex: n.Name,
+	}
+	if n.Spec.ProviderID != "" {
+		lookups[machineProviderIDIndex] = n.Spec.ProviderID
+	}
+	for field, value := range lookups {
+		machines := &machinev1.MachineList{}
+		if err := r.Client.List(ctx, machines, client.MatchingFields{field: value}); err != nil {
+			return nil, fmt.Errorf("unable to list machines: %w", err)
+		}
+		if len(machines.Items) > 0 {
+			return &machines.Items[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// Build a machine for the node with linker annotations derived from the node addresses
+// The machine is owned by the MachineSet when one is given
+func (r *NodeAdoptReconciler) machineForNode(n *corev1.Node, ms *machinev1.MachineSet) *machinev1.Machine {
+	m := &machinev1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      n.Name,
+			Namespace: r.Namespace,
+			Labels:    map[string]string{},
+			Annotations: map[string]string{
+				getAnnotationKey(AdoptedNodeAnnotation): n.Name,
+				getAnnotationKey(NodeNameAnnotation):    n.Name,
+			},
+		},
+	}
+
+	res := &provision.Result{Addresses: n.Status.Addresses}
+	if n.Spec.ProviderID != "" {
+		res.ProviderID = &n.Spec.ProviderID
+	}
+	applyProvisionResult(m, res)
+	if macs := nodeMACAddresses(n); len(macs) > 0 {
+		m.Annotations[getAnnotationKey(MACAddressAnnotation)] = strings.Join(macs, ",")
+	}
+
+	if role := nodeRole(n); role != "" {
+		m.Labels[MachineRoleLabel] = role
+		m.Labels[machineTypeLabel] = role
+	}
+
+	if ms != nil {
+		m.Annotations[getAnnotationKey(PendingReplicaAnnotation)] = ms.Name
+		for key, value := range ms.Spec.Template.Labels {
+			m.Labels[key] = value
+		}
+		m.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(ms, machinev1.GroupVersion.WithKind("MachineSet"))}
+	}
+	return m
+}
+
+// Role of a node from its node-role labels, control plane roles win over worker
+func nodeRole(n *corev1.Node) string {
+	role := ""
+	for key := range n.Labels {
+		switch key {
+		case nodeRoleLabelPrefix + "master", nodeRoleLabelPrefix + "control-plane":
+			return "master"
+		case nodeRoleLabelPrefix + "infra":
+			role = "infra"
+		case nodeRoleLabelPrefix + "worker":
+			if role == "" {
+				role = "worker"
+			}
+		}
+	}
+	return role
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeAdoptReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("machine-node-linker")
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("nodeadopt").
+		For(&corev1.Node{}).
+		Complete(r)
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Node adoption", func() {
+
+	const (
+		NodeName         = "worker-0"
+		MachineNamespace = "openshift-machine-api"
+		NodeIP           = "10.0.0.10"
+	)
+
+	var (
+		ctx     context.Context
+		r       *NodeAdoptReconciler
+		rawNode *corev1.Node
+		objects []client.Object
+
+		selector   labels.Selector
+		machineSet string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawNode = &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   NodeName,
+				Labels: map[string]string{"node-role.kubernetes.io/worker": ""},
+			},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{
+					{Type: corev1.NodeInternalIP, Address: NodeIP},
+					{Type: corev1.NodeHostName, Address: NodeName},
+				},
+			},
+		}
+		objects = nil
+		selector = labels.Everything()
+		machineSet = ""
+	})
+
+	reconcile := func() {
+		r = &NodeAdoptReconciler{
+			Client:         newFakeClient(append(objects, rawNode)...),
+			Scheme:         scheme.Scheme,
+			NodeSelector:   selector,
+			Namespace:      MachineNamespace,
+			MachineSetName: machineSet,
+			Recorder:       record.NewFakeRecorder(10),
+		}
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: NodeName}})
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	It("Should create an annotated machine for an orphan node", func() {
+		reconcile()
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName, Namespace: MachineNamespace}, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), NodeIP))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(HostnameAnnotation), NodeName))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(AdoptedNodeAnnotation), NodeName))
+		Expect(m.Labels).Should(HaveKeyWithValue(MachineRoleLabel, "worker"))
+	})
+
+	It("Should not adopt a node that is already linked", func() {
+		objects = append(objects, &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: MachineNamespace},
+			Status:     machinev1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: NodeName}},
+		})
+		reconcile()
+		machines := &machinev1.MachineList{}
+		Expect(r.Client.List(ctx, machines)).Should(Succeed())
+		Expect(machines.Items).Should(HaveLen(1))
+	})
+
+	It("Should not adopt a node outside the selector", func() {
+		var err error
+		selector, err = labels.Parse("node-role.kubernetes.io/infra")
+		Expect(err).ShouldNot(HaveOccurred())
+		reconcile()
+		machines := &machinev1.MachineList{}
+		Expect(r.Client.List(ctx, machines)).Should(Succeed())
+		Expect(machines.Items).Should(BeEmpty())
+	})
+
+	It("Should make the configured machineset the controller of the machine", func() {
+		objects = append(objects, &machinev1.MachineSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: MachineNamespace},
+			Spec: machinev1.MachineSetSpec{
+				Replicas: ptr.To[int32](2),
+				Template: machinev1.MachineTemplateSpec{
+					ObjectMeta: machinev1.ObjectMeta{Labels: map[string]string{"machine.openshift.io/cluster-api-machineset": "workers"}},
+				},
+			},
+		})
+		machineSet = "workers"
+		reconcile()
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName, Namespace: MachineNamespace}, m)).Should(Succeed())
+		Expect(m.Labels).Should(HaveKeyWithValue("machine.openshift.io/cluster-api-machineset", "workers"))
+		Expect(metav1.GetControllerOf(m)).ShouldNot(BeNil())
+		Expect(metav1.GetControllerOf(m).Name).Should(Equal("workers"))
+
+		ms := &machinev1.MachineSet{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: "workers", Namespace: MachineNamespace}, ms)).Should(Succeed())
+		Expect(ms.Spec.Replicas).Should(HaveValue(BeEquivalentTo(3)))
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName, Namespace: MachineNamespace}, m)).Should(Succeed())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(PendingReplicaAnnotation)))
+	})
+
+	It("Should raise the replicas for an adopted machine exactly once after a failed attempt", func() {
+		objects = append(objects,
+			&machinev1.MachineSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: MachineNamespace},
+				Spec:       machinev1.MachineSetSpec{Replicas: ptr.To[int32](2)},
+			},
+			&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      NodeName,
+					Namespace: MachineNamespace,
+					Annotations: map[string]string{
+						getAnnotationKey(AdoptedNodeAnnotation):    NodeName,
+						getAnnotationKey(NodeNameAnnotation):       NodeName,
+						getAnnotationKey(PendingReplicaAnnotation): "workers",
+					},
+				},
+			},
+		)
+		machineSet = "workers"
+		reconcile()
+
+		ms := &machinev1.MachineSet{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: "workers", Namespace: MachineNamespace}, ms)).Should(Succeed())
+		Expect(ms.Spec.Replicas).Should(HaveValue(BeEquivalentTo(3)))
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName, Namespace: MachineNamespace}, m)).Should(Succeed())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(PendingReplicaAnnotation)))
+
+		By("Leaving the replicas alone on the next reconcile")
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: NodeName}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: "workers", Namespace: MachineNamespace}, ms)).Should(Succeed())
+		Expect(ms.Spec.Replicas).Should(HaveValue(BeEquivalentTo(3)))
+	})
+
+	It("Should report a machine with the node name that was not created for it", func() {
+		objects = append(objects, &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeName, Namespace: MachineNamespace},
+		})
+		reconcile()
+		Expect(r.Recorder.(*record.FakeRecorder).Events).Should(Receive(ContainSubstring("AdoptConflict")))
+	})
+})
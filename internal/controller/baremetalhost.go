@@ -0,0 +1,178 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// Reference to a metal3 BareMetalHost in the machine namespace, as name or namespace/name
+	BareMetalHostAnnotation = "baremetalhost"
+
+	addressSourceBareMetalHost = "baremetalhost"
+
+	// Provider condition reporting whether the referenced BareMetalHost was found
+	providerConditionBareMetalHost machinev1.ConditionType = "BareMetalHostFound"
+	reasonBareMetalHostNotFound                            = "BareMetalHostNotFound"
+
+	// How often a referenced BareMetalHost is read when BareMetalHosts are not watched
+	bareMetalHostPollInterval = time.Minute
+)
+
+// Read as unstructured so the metal3 CRDs are not required
+var bareMetalHostGVK = schema.GroupVersionKind{Group: "metal3.io", Version: "v1alpha1", Kind: "BareMetalHost"}
+
+var errBareMetalHostNotFound = errors.New("baremetalhost not found")
+
+// The parts of a BareMetalHost status used for the machine
+type bareMetalHost struct {
+	Addresses     []corev1.NodeAddress
+	InstanceState string
+}
+
+func hasBareMetalHost(m *machinev1.Machine) bool {
+	return m.Annotations[getAnnotationKey(BareMetalHostAnnotation)] != ""
+}
+
+// Resolve the baremetalhost annotation of the machine
+func bareMetalHostKey(m *machinev1.Machine) apitypes.NamespacedName {
+	ref := m.Annotations[getAnnotationKey(BareMetalHostAnnotation)]
+	if namespace, name, ok := strings.Cut(ref, "/"); ok {
+		return apitypes.NamespacedName{Namespace: namespace, Name: name}
+	}
+	return apitypes.NamespacedName{Namespace: m.Namespace, Name: ref}
+}
+
+// Read the BareMetalHost referenced by the machine from the cache
+// Returns nil when the machine has no reference, and errBareMetalHostNotFound when the host or the metal3 CRDs are missing,
+// or the reference is outside the machine namespace
+func (r *MachineReconciler) bareMetalHostFor(ctx context.Context, m *machinev1.Machine) (*bareMetalHost, error) {
+	if !hasBareMetalHost(m) {
+		return nil, nil
+	}
+	key := bareMetalHostKey(m)
+	if key.Namespace != m.Namespace {
+		return nil, fmt.Errorf("%w: %s is outside the machine namespace", errBareMetalHostNotFound, key)
+	}
+	// Unstructured objects are not cached by the manager client
+	var reader client.Reader = r.Client
+	if r.bareMetalHostReader != nil {
+		reader = r.bareMetalHostReader
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(bareMetalHostGVK)
+	if err := reader.Get(ctx, key, u); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			log.FromContext(ctx).Info("BareMetalHost not found", "BareMetalHost", key)
+			return nil, fmt.Errorf("%w: %s", errBareMetalHostNotFound, key)
+		}
+		return nil, fmt.Errorf("unable to get baremetalhost: %w", err)
+	}
+	return bareMetalHostFromUnstructured(u), nil
+}
+
+// Addresses from status.hardware and the instance state from status.poweredOn
+func bareMetalHostFromUnstructured(u *unstructured.Unstructured) *bareMetalHost {
+	bmh := &bareMetalHost{}
+	seen := map[string]bool{}
+	nics, _, _ := unstructured.NestedSlice(u.Object, "status", "hardware", "nics")
+	for _, nic := range nics {
+		fields, ok := nic.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ip, _, _ := unstructured.NestedString(fields, "ip")
+		if ip == "" || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		bmh.Addresses = append(bmh.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+	}
+	if hostname, _, _ := unstructured.NestedString(u.Object, "status", "hardware", "hostname"); hostname != "" {
+		bmh.Addresses = append(bmh.Addresses,
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: hostname},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: hostname},
+		)
+	}
+	if poweredOn, found, _ := unstructured.NestedBool(u.Object, "status", "poweredOn"); found {
+		bmh.InstanceState = InstanceStateStopped
+		if poweredOn {
+			bmh.InstanceState = InstanceStateRunning
+		}
+	}
+	return bmh
+}
+
+// Map a BareMetalHost to the machines referencing it
+func (r *MachineReconciler) machinesForBareMetalHost(ctx context.Context, o client.Object) []reconcile.Request {
+	machines := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, machines, client.InNamespace(o.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list machines")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range machines.Items {
+		m := &machines.Items[i]
+		if hasBareMetalHost(m) && bareMetalHostKey(m) == client.ObjectKeyFromObject(o) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(m)})
+		}
+	}
+	return requests
+}
+
+// Record whether the referenced BareMetalHost was found
+func (ps *providerStatus) setBareMetalHostFound(err error) {
+	if err == nil {
+		ps.setCondition(machinev1.Condition{
+			Type:   providerConditionBareMetalHost,
+			Status: corev1.ConditionTrue,
+		})
+		return
+	}
+	ps.setCondition(machinev1.Condition{
+		Type:     providerConditionBareMetalHost,
+		Status:   corev1.ConditionFalse,
+		Reason:   reasonBareMetalHostNotFound,
+		Severity: machinev1.ConditionSeverityWarning,
+		Message:  err.Error(),
+	})
+}
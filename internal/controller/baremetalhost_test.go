@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("BareMetalHost address source", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		HostName         = "worker-0"
+		HostNamespace    = MachineNamespace
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	newBareMetalHost := func(poweredOn bool) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"poweredOn": poweredOn,
+				"hardware": map[string]interface{}{
+					"hostname": "worker-0.example.com",
+					"nics": []interface{}{
+						map[string]interface{}{"name": "eno1", "mac": "52:54:00:aa:bb:01", "ip": "10.0.0.5"},
+						map[string]interface{}{"name": "eno2", "mac": "52:54:00:aa:bb:02", "ip": "fd00::5"},
+						map[string]interface{}{"name": "eno3", "mac": "52:54:00:aa:bb:03"},
+					},
+				},
+			},
+		}}
+		u.SetGroupVersionKind(bareMetalHostGVK)
+		u.SetName(HostName)
+		u.SetNamespace(HostNamespace)
+		return u
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        MachineName,
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{getAnnotationKey(BareMetalHostAnnotation): HostNamespace + "/" + HostName},
+			},
+		}
+	})
+
+	reconcile := func(objs ...client.Object) ctrl.Result {
+		r = newFakeMachineReconciler(append(objs, rawMachine)...)
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	getMachine := func() (*machinev1.Machine, *providerStatus) {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		return m, ps
+	}
+
+	It("Should copy addresses and power state from the BareMetalHost", func() {
+		res := reconcile(newBareMetalHost(true))
+		Expect(res.RequeueAfter).Should(Equal(bareMetalHostPollInterval))
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "fd00::5"},
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: "worker-0.example.com"},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: "worker-0.example.com"},
+		))
+		Expect(ps.InstanceState).Should(HaveValue(Equal(InstanceStateRunning)))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceBareMetalHost}))
+		Expect(ps.getCondition(providerConditionBareMetalHost)).Should(HaveField("Status", corev1.ConditionTrue))
+	})
+
+	It("Should prefer address annotations over the BareMetalHost", func() {
+		rawMachine.Annotations[getAnnotationKey(InternalIPAnnotation)] = "192.168.1.5"
+		reconcile(newBareMetalHost(false))
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.1.5"}))
+		Expect(m.Status.Addresses).ShouldNot(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}))
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeHostName, Address: "worker-0.example.com"}))
+		Expect(ps.InstanceState).Should(HaveValue(Equal(InstanceStateStopped)))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceAnnotations, addressSourceBareMetalHost}))
+	})
+
+	It("Should resolve a name in the machine namespace", func() {
+		rawMachine.Annotations[getAnnotationKey(BareMetalHostAnnotation)] = HostName
+		Expect(bareMetalHostKey(rawMachine)).Should(Equal(types.NamespacedName{Namespace: MachineNamespace, Name: HostName}))
+	})
+
+	It("Should report a missing BareMetalHost", func() {
+		reconcile()
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(BeEmpty())
+		Expect(ps.getCondition(providerConditionBareMetalHost)).Should(And(
+			HaveField("Status", corev1.ConditionFalse),
+			HaveField("Reason", reasonBareMetalHostNotFound),
+		))
+	})
+
+	It("Should reject a BareMetalHost outside the machine namespace", func() {
+		bmh := newBareMetalHost(true)
+		bmh.SetNamespace("metal3")
+		rawMachine.Annotations[getAnnotationKey(BareMetalHostAnnotation)] = "metal3/" + HostName
+		reconcile(bmh)
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(BeEmpty())
+		Expect(ps.getCondition(providerConditionBareMetalHost)).Should(And(
+			HaveField("Status", corev1.ConditionFalse),
+			HaveField("Message", ContainSubstring("outside the machine namespace")),
+		))
+	})
+
+	It("Should map a BareMetalHost to the machines referencing it", func() {
+		reconcile(newBareMetalHost(true))
+		Expect(r.machinesForBareMetalHost(ctx, newBareMetalHost(true))).Should(ConsistOf(ctrl.Request{NamespacedName: lookupKey}))
+	})
+})
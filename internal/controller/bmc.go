@@ -0,0 +1,263 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/bmc"
+)
+
+const (
+	// Redfish address of the BMC, ex. redfish://10.0.9.12/redfish/v1/Systems/1
+	BMCAddressAnnotation = "bmc-address"
+	// Secret of the BMC credentials type in the namespace of the machine with the username and password keys for the BMC
+	BMCCredentialsAnnotation = "bmc-credentials"
+	// Skip verification of the BMC certificate when set to true
+	BMCDisableCertificateVerificationAnnotation = "bmc-disable-certificate-verification"
+	// Power action to run once through the BMC: on, off or reset
+	PowerActionAnnotation = "power-action"
+
+	BMCUsernameKey = "username"
+	BMCPasswordKey = "password"
+	// Type BMC credentials Secrets must have, other Secrets are never read
+	BMCCredentialsSecretType corev1.SecretType = "machine-node-linker.github.com/bmc-credentials"
+
+	PowerActionOn    = "on"
+	PowerActionOff   = "off"
+	PowerActionReset = "reset"
+
+	// Instance states derived from the BMC power state
+	InstanceStateStopped  = "stopped"
+	InstanceStateStarting = "starting"
+	InstanceStateStopping = "stopping"
+
+	// Provider condition reporting whether the BMC answered the last poll
+	providerConditionBMC machinev1.ConditionType = "BMCReachable"
+	reasonBMCError                               = "BMCError"
+
+	bmcTimeout              = 30 * time.Second
+	defaultBMCPollInterval  = time.Minute
+	powerActionRequeueAfter = 30 * time.Second
+)
+
+// Last power state polled from the BMC of a machine
+type bmcPower struct {
+	state  string
+	err    error
+	polled time.Time
+}
+
+// Power states by machine UID, so every reconcile does not poll the BMC
+// Entries are dropped once their poll interval has passed, so deleted machines do not stay cached
+type bmcPowerCache struct {
+	mu      sync.Mutex
+	entries map[apitypes.UID]bmcPower
+}
+
+func (c *bmcPowerCache) get(uid apitypes.UID) (bmcPower, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.entries[uid]
+	return p, ok
+}
+
+func (c *bmcPowerCache) set(uid apitypes.UID, p bmcPower, interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[apitypes.UID]bmcPower{}
+	}
+	for key, entry := range c.entries {
+		if time.Since(entry.polled) > interval {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[uid] = p
+}
+
+func (c *bmcPowerCache) forget(uid apitypes.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, uid)
+}
+
+// Machine has a BMC
+func hasBMC(m *machinev1.Machine) bool {
+	return m.Annotations[getAnnotationKey(BMCAddressAnnotation)] != ""
+}
+
+// Build a Redfish client for the BMC of the machine
+func (r *MachineReconciler) redfishFor(ctx context.Context, m *machinev1.Machine) (*bmc.Redfish, error) {
+	var username, password string
+	if name := m.Annotations[getAnnotationKey(BMCCredentialsAnnotation)]; name != "" {
+		secret, err := r.KubeClient.CoreV1().Secrets(m.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get BMC credentials: %w", err)
+		}
+		if secret.Type != BMCCredentialsSecretType {
+			return nil, fmt.Errorf("BMC credentials secret %s is not of type %s", name, BMCCredentialsSecretType)
+		}
+		username, password = string(secret.Data[BMCUsernameKey]), string(secret.Data[BMCPasswordKey])
+	}
+	insecure, _ := strconv.ParseBool(m.Annotations[getAnnotationKey(BMCDisableCertificateVerificationAnnotation)])
+	return bmc.NewRedfish(m.Annotations[getAnnotationKey(BMCAddressAnnotation)], username, password, insecure, bmcTimeout)
+}
+
+// Power state of the machine from its BMC, polled at most once per BMCPollInterval
+// Also returns how long until the next poll
+func (r *MachineReconciler) bmcPowerState(ctx context.Context, m *machinev1.Machine) (string, time.Duration, error) {
+	interval := r.BMCPollInterval
+	if interval <= 0 {
+		interval = defaultBMCPollInterval
+	}
+	if p, ok := r.bmcCache.get(m.UID); ok {
+		if remaining := time.Until(p.polled.Add(interval)); remaining > 0 {
+			return p.state, remaining, p.err
+		}
+	}
+	p := bmcPower{polled: time.Now()}
+	client, err := r.redfishFor(ctx, m)
+	if err == nil {
+		p.state, err = client.PowerState(ctx)
+	}
+	p.err = err
+	r.bmcCache.set(m.UID, p, interval)
+	return p.state, interval, p.err
+}
+
+// Instance state for a Redfish power state
+func instanceStateFromPower(power string) string {
+	switch power {
+	case bmc.PowerOn:
+		return InstanceStateRunning
+	case bmc.PowerOff:
+		return InstanceStateStopped
+	case bmc.PowerPoweringOn:
+		return InstanceStateStarting
+	case bmc.PowerPoweringOff:
+		return InstanceStateStopping
+	default:
+		return strings.ToLower(power)
+	}
+}
+
+// Run a power action through the BMC of the machine
+func (r *MachineReconciler) powerAction(ctx context.Context, m *machinev1.Machine, action string) error {
+	var resetType string
+	switch action {
+	case PowerActionOn:
+		resetType = bmc.ResetOn
+	case PowerActionOff:
+		resetType = bmc.ResetForceOff
+	case PowerActionReset:
+		resetType = bmc.ResetForceRestart
+	default:
+		return fmt.Errorf("unknown power action %q", action)
+	}
+	client, err := r.redfishFor(ctx, m)
+	if err != nil {
+		return err
+	}
+	if err := client.Reset(ctx, resetType); err != nil {
+		return err
+	}
+	// Poll the new power state on the next reconcile
+	r.bmcCache.forget(m.UID)
+	return nil
+}
+
+// Run the power action requested by the power-action annotation and remove the annotation
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcilePowerAction(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	action, ok := m.Annotations[getAnnotationKey(PowerActionAnnotation)]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx)
+
+	switch action {
+	case PowerActionOn, PowerActionOff, PowerActionReset:
+		if !hasBMC(m) {
+			r.recordEvent(m, corev1.EventTypeWarning, "PowerActionFailed", "Power action %q needs the %s annotation", action, getAnnotationKey(BMCAddressAnnotation))
+			break
+		}
+		// Powering off or resetting a control plane machine takes its etcd member down, keep the annotation while that would break quorum
+		if action != PowerActionOn {
+			if res, err := r.guardEtcdQuorum(ctx, m, fmt.Sprintf("Power action %q", action)); err != nil || !res.IsZero() {
+				return res, err
+			}
+		}
+		// Remove the annotation before acting, so a stale copy of the machine cannot run the action again
+		if err := r.removePowerAction(ctx, m); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.powerAction(ctx, m, action); err != nil {
+			logger.Info("Power action failed", "Action", action, "Error", err.Error())
+			r.recordEvent(m, corev1.EventTypeWarning, "PowerActionFailed", "Power action %q failed: %v", action, err)
+			// Put the annotation back to retry
+			patch := client.MergeFrom(m.DeepCopy())
+			m.Annotations[getAnnotationKey(PowerActionAnnotation)] = action
+			if err := r.Client.Patch(ctx, m, patch); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to restore power action: %w", err)
+			}
+			return ctrl.Result{RequeueAfter: powerActionRequeueAfter}, nil
+		}
+		logger.Info("Ran power action", "Action", action)
+		r.recordEvent(m, corev1.EventTypeNormal, "PowerAction", "Ran power action %q", action)
+		return ctrl.Result{Requeue: true}, nil
+	default:
+		r.recordEvent(m, corev1.EventTypeWarning, "PowerActionFailed", "Unknown power action %q", action)
+	}
+
+	if err := r.removePowerAction(ctx, m); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Remove the power-action annotation, failing with a conflict if the machine changed since it was read
+func (r *MachineReconciler) removePowerAction(ctx context.Context, m *machinev1.Machine) error {
+	patch := client.MergeFromWithOptions(m.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	delete(m.Annotations, getAnnotationKey(PowerActionAnnotation))
+	if err := r.Client.Patch(ctx, m, patch); err != nil {
+		return fmt.Errorf("unable to remove power action: %w", err)
+	}
+	return nil
+}
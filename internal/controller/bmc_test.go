@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Redfish BMC", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		SecretName       = "test-machine-bmc"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		mock       *redfishMock
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = newRedfishMock("admin", "password")
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				UID:       "machine-uid",
+				Annotations: map[string]string{
+					getAnnotationKey(InternalIPAnnotation):     "10.0.0.5",
+					getAnnotationKey(BMCAddressAnnotation):     mock.address(),
+					getAnnotationKey(BMCCredentialsAnnotation): SecretName,
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mock.server.Close()
+	})
+
+	reconcile := func() ctrl.Result {
+		r = newFakeMachineReconciler(rawMachine)
+		r.KubeClient = kubefake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: SecretName, Namespace: MachineNamespace},
+			Type:       BMCCredentialsSecretType,
+			Data:       map[string][]byte{BMCUsernameKey: []byte("admin"), BMCPasswordKey: []byte("password")},
+		})
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	providerStatus := func() *providerStatus {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		return ps
+	}
+
+	It("Should poll the power state into the instance state", func() {
+		res := reconcile()
+		Expect(res.RequeueAfter).Should(BeNumerically(">", 0))
+		ps := providerStatus()
+		Expect(ps.InstanceState).Should(HaveValue(Equal(InstanceStateRunning)))
+		Expect(ps.getCondition(providerConditionBMC)).Should(HaveField("Status", corev1.ConditionTrue))
+	})
+
+	It("Should run the power action and remove the annotation", func() {
+		rawMachine.Annotations[getAnnotationKey(PowerActionAnnotation)] = PowerActionOff
+		reconcile()
+		Expect(mock.resetTypes()).Should(Equal([]string{"ForceOff"}))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(PowerActionAnnotation)))
+		Expect(providerStatus().InstanceState).Should(HaveValue(Equal(InstanceStateStopped)))
+	})
+
+	It("Should not run the power action again from a stale machine", func() {
+		rawMachine.Annotations[getAnnotationKey(PowerActionAnnotation)] = PowerActionReset
+		reconcile()
+		Expect(mock.resetTypes()).Should(Equal([]string{"ForceRestart"}))
+
+		By("Reconciling a copy of the machine read before the action ran")
+		stale := rawMachine.DeepCopy()
+		stale.ResourceVersion = "1"
+		_, err := r.reconcilePowerAction(ctx, stale)
+		Expect(apierrors.IsConflict(err)).Should(BeTrue())
+		Expect(mock.resetTypes()).Should(Equal([]string{"ForceRestart"}))
+	})
+
+	It("Should hold the power action while etcd would lose quorum", func() {
+		rawMachine.Labels = map[string]string{MachineRoleLabel: "master"}
+		rawMachine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "test-node"}
+		rawMachine.Annotations[getAnnotationKey(PowerActionAnnotation)] = PowerActionReset
+		etcdPod := func(node string, ready corev1.ConditionStatus) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "etcd-" + node, Namespace: etcdNamespace, Labels: map[string]string{"app": "etcd"}},
+				Spec:       corev1.PodSpec{NodeName: node},
+				Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+			}
+		}
+		r = newFakeMachineReconciler(rawMachine)
+		r.GuardControlPlane = true
+		r.KubeClient = kubefake.NewSimpleClientset(
+			etcdPod("test-node", corev1.ConditionTrue),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionFalse),
+		)
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(etcdGuardRequeueAfter))
+		Expect(mock.resetTypes()).Should(BeEmpty())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKey(getAnnotationKey(PowerActionAnnotation)))
+		Expect(conditions.Get(m, conditionEtcdQuorumSafe)).Should(HaveField("Status", corev1.ConditionFalse))
+	})
+
+	It("Should report an unreachable BMC", func() {
+		rawMachine.Annotations[getAnnotationKey(BMCCredentialsAnnotation)] = "missing"
+		reconcile()
+		ps := providerStatus()
+		Expect(ps.getCondition(providerConditionBMC)).Should(HaveField("Reason", reasonBMCError))
+	})
+
+	It("Should only read BMC credentials from Secrets of the BMC credentials type", func() {
+		rawMachine.Annotations[getAnnotationKey(BMCCredentialsAnnotation)] = "opaque"
+		rawMachine.Annotations[getAnnotationKey(PowerActionAnnotation)] = PowerActionOff
+		r = newFakeMachineReconciler(rawMachine)
+		r.KubeClient = kubefake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: MachineNamespace},
+			Data:       map[string][]byte{BMCUsernameKey: []byte("admin"), BMCPasswordKey: []byte("password")},
+		})
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(powerActionRequeueAfter))
+		Expect(mock.resetTypes()).Should(BeEmpty())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(PowerActionAnnotation), PowerActionOff))
+	})
+})
+
+// In-process Redfish BMC with a single system
+type redfishMock struct {
+	mu     sync.Mutex
+	power  string
+	resets []string
+	server *httptest.Server
+}
+
+func newRedfishMock(username, password string) *redfishMock {
+	mock := &redfishMock{power: "On"}
+	mock.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if u, p, ok := req.BasicAuth(); !ok || u != username || p != password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/redfish/v1/Systems":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"Members": []map[string]string{{"@odata.id": "/redfish/v1/Systems/1"}},
+			})
+		case req.Method == http.MethodGet && req.URL.Path == "/redfish/v1/Systems/1":
+			_ = json.NewEncoder(w).Encode(map[string]string{"Id": "1", "PowerState": mock.power})
+		case req.Method == http.MethodPost && req.URL.Path == "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset":
+			body := map[string]string{}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mock.resets = append(mock.resets, body["ResetType"])
+			switch body["ResetType"] {
+			case "On", "ForceRestart":
+				mock.power = "On"
+			case "ForceOff":
+				mock.power = "Off"
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	return mock
+}
+
+// BMC address of the mock in the redfish+http form
+func (mock *redfishMock) address() string {
+	return "redfish+http://" + strings.TrimPrefix(mock.server.URL, "http://")
+}
+
+func (mock *redfishMock) resetTypes() []string {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	return append([]string(nil), mock.resets...)
+}
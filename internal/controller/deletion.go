@@ -0,0 +1,295 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubectl/pkg/drain"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Finalizer added to managed machines so the linked node can be removed on deletion
+	NodeCleanupFinalizer = AnnotationBase + "/node-cleanup"
+
+	// Skips draining the node when set on the machine, shared with the machine-api-operator
+	ExcludeNodeDrainingAnnotation = "machine.openshift.io/exclude-node-draining"
+
+	// Used while the machine is being deleted
+	phaseDeleting = "Deleting"
+
+	// Machine condition set once the linked node has been removed
+	conditionNodeDeleted  machinev1.ConditionType = "NodeDeleted"
+	reasonNodeDeleteError                         = "NodeDeleteError"
+
+	// A single drain attempt is limited so other machines can be reconciled
+	drainAttemptTimeout = 20 * time.Second
+	drainRequeueAfter   = 20 * time.Second
+)
+
+// Add the node cleanup finalizer to machines this operator manages
+// The finalizer also holds the machine until the delete hook has run and its host is released,
+// including a host it may claim by MAC address, and holds control plane machines until the etcd quorum guard lets them go
+// Returns true when the machine was updated
+func (r *MachineReconciler) ensureFinalizer(ctx context.Context, m *machinev1.Machine) (bool, error) {
+	guarded := r.GuardControlPlane && isControlPlaneMachine(m)
+	claimsByMAC := r.HostPools && len(machineMACAddresses(m)) > 0
+	if !(r.DeleteNodes || r.Provisioner != nil || hasHostClaim(m) || claimsByMAC || guarded) || !isLinkerManaged(m) || controllerutil.ContainsFinalizer(m, NodeCleanupFinalizer) {
+		return false, nil
+	}
+	controllerutil.AddFinalizer(m, NodeCleanupFinalizer)
+	if err := r.Client.Update(ctx, m); err != nil {
+		return false, fmt.Errorf("unable to add finalizer: %w", err)
+	}
+	return true, nil
+}
+
+// Handle a machine with a deletionTimestamp
+// Cordons and drains the linked node, deletes it, runs the delete hook, releases its host, then releases the finalizer
+func (r *MachineReconciler) reconcileDelete(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(m, NodeCleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if _, ok := m.Annotations[getAnnotationKey(PhaseAnnotation)]; ok && (m.Status.Phase == nil || *m.Status.Phase != phaseDeleting) {
+		phase := phaseDeleting
+		m.Status.Phase = &phase
+		logger.Info("New Phase", "Status", m.Status)
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Report lifecycle hooks before acting on them
+	if setLifecycleHookConditions(m) {
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// The member goes away with the machine even when the node is left behind, so guard every deletion
+	if m.Status.NodeRef != nil {
+		if deleted := conditions.Get(m, conditionNodeDeleted); deleted == nil || deleted.Status != corev1.ConditionTrue {
+			if res, err := r.guardEtcdQuorum(ctx, m, "Machine deletion"); err != nil || !res.IsZero() {
+				return res, err
+			}
+		}
+	}
+
+	if r.DeleteNodes && m.Status.NodeRef != nil {
+		if drained := conditions.Get(m, machinev1.MachineDrained); drained == nil || drained.Status != corev1.ConditionTrue {
+			// pre-drain.delete lifecycle hook
+			// Return early without error, the machine is reconciled again when the hook owner removes it
+			if len(m.Spec.LifecycleHooks.PreDrain) > 0 {
+				logger.Info("Not draining machine: lifecycle blocked by pre-drain hook")
+				r.recordEvent(m, corev1.EventTypeNormal, "DrainBlocked", "Drain blocked by pre-drain hook")
+				return ctrl.Result{}, nil
+			}
+			return r.drainMachineNode(ctx, m)
+		}
+	}
+
+	// pre-terminate.delete lifecycle hook
+	// Neither the node nor the finalizer is removed until the hook owner removes it
+	if len(m.Spec.LifecycleHooks.PreTerminate) > 0 {
+		logger.Info("Not deleting node: lifecycle blocked by pre-terminate hook")
+		r.recordEvent(m, corev1.EventTypeNormal, "TerminateBlocked", "Node deletion blocked by pre-terminate hook")
+		return ctrl.Result{}, nil
+	}
+
+	if r.DeleteNodes && m.Status.NodeRef != nil {
+		if deleted := conditions.Get(m, conditionNodeDeleted); deleted == nil || deleted.Status != corev1.ConditionTrue {
+			if err := r.deleteNode(ctx, m.Status.NodeRef.Name); err != nil {
+				conditions.MarkFalse(m, conditionNodeDeleted, reasonNodeDeleteError, machinev1.ConditionSeverityWarning, "could not delete node: %v", err)
+				r.recordEvent(m, corev1.EventTypeWarning, "NodeDeleteFailed", "Node %q could not be deleted: %v", m.Status.NodeRef.Name, err)
+				if uErr := r.Client.Status().Update(ctx, m); uErr != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update client: %w", uErr)
+				}
+				return ctrl.Result{}, err
+			}
+			conditions.MarkTrue(m, conditionNodeDeleted)
+			r.recordEvent(m, corev1.EventTypeNormal, "NodeDeleted", "Node %q deleted", m.Status.NodeRef.Name)
+			if err := r.Client.Status().Update(ctx, m); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+			}
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
+	if res, err := r.reconcileDeprovision(ctx, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	if err := r.releaseHost(ctx, m); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	controllerutil.RemoveFinalizer(m, NodeCleanupFinalizer)
+	if err := r.Client.Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to remove finalizer: %w", err)
+	}
+	r.bmcCache.forget(m.UID)
+	r.netboxCache.forget(m.UID)
+	logger.Info("Machine deletion successful")
+	return ctrl.Result{}, nil
+}
+
+// Set the MachineDrainable and MachineTerminable conditions from the lifecycle hooks in the spec
+// Returns true when the conditions changed
+func setLifecycleHookConditions(m *machinev1.Machine) bool {
+	original := m.Status.Conditions.DeepCopy()
+
+	if len(m.Spec.LifecycleHooks.PreDrain) > 0 {
+		conditions.MarkFalse(m, machinev1.MachineDrainable, machinev1.MachineHookPresent, machinev1.ConditionSeverityWarning,
+			"Drain operation currently blocked by: %+v", m.Spec.LifecycleHooks.PreDrain)
+	} else {
+		conditions.MarkTrue(m, machinev1.MachineDrainable)
+	}
+
+	if len(m.Spec.LifecycleHooks.PreTerminate) > 0 {
+		conditions.MarkFalse(m, machinev1.MachineTerminable, machinev1.MachineHookPresent, machinev1.ConditionSeverityWarning,
+			"Terminate operation currently blocked by: %+v", m.Spec.LifecycleHooks.PreTerminate)
+	} else {
+		conditions.MarkTrue(m, machinev1.MachineTerminable)
+	}
+
+	return !reflect.DeepEqual(original, m.Status.Conditions)
+}
+
+// Cordon and drain the linked node, recording the result in the MachineDrained condition
+func (r *MachineReconciler) drainMachineNode(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	drainFinished := conditions.TrueCondition(machinev1.MachineDrained)
+
+	switch _, exclude := m.Annotations[ExcludeNodeDrainingAnnotation]; {
+	case exclude:
+		r.recordEvent(m, corev1.EventTypeNormal, "DrainSkipped", "Node drain skipped")
+		drainFinished.Message = "Node drain skipped"
+	case r.DrainTimeout > 0 && m.DeletionTimestamp != nil && time.Since(m.DeletionTimestamp.Time) > r.DrainTimeout:
+		r.recordEvent(m, corev1.EventTypeWarning, "DrainTimeout", "Node drain did not finish within %v", r.DrainTimeout)
+		drainFinished.Message = fmt.Sprintf("Node drain timed out after %v", r.DrainTimeout)
+	default:
+		r.recordEvent(m, corev1.EventTypeNormal, "DrainProceeds", "Node drain proceeds")
+		if err := r.drainNode(ctx, m.Status.NodeRef.Name); err != nil {
+			conditions.MarkFalse(m, machinev1.MachineDrained, machinev1.MachineDrainError, machinev1.ConditionSeverityWarning, "could not drain machine: %v", err)
+			r.recordEvent(m, corev1.EventTypeNormal, "DrainRequeued", "Node drain requeued: %v", err)
+			if uErr := r.Client.Status().Update(ctx, m); uErr != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", uErr)
+			}
+			return ctrl.Result{RequeueAfter: drainRequeueAfter}, nil
+		}
+		r.recordEvent(m, corev1.EventTypeNormal, "DrainSucceeded", "Node drain succeeded")
+		drainFinished.Message = "Drain finished successfully"
+	}
+
+	conditions.Set(m, drainFinished)
+	if err := r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Cordon the node and evict its pods, honoring PodDisruptionBudgets
+func (r *MachineReconciler) drainNode(ctx context.Context, nodeName string) error {
+	logger := log.FromContext(ctx)
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: nodeName}, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Node not found, it may have already been deleted", "Node", nodeName)
+			return nil
+		}
+		return fmt.Errorf("unable to get node: %v", err)
+	}
+
+	drainer := &drain.Helper{
+		Ctx:                 ctx,
+		Client:              r.KubeClient,
+		Force:               true,
+		IgnoreAllDaemonSets: true,
+		DeleteEmptyDirData:  true,
+		GracePeriodSeconds:  -1,
+		Timeout:             drainAttemptTimeout,
+		OnPodDeletedOrEvicted: func(pod *corev1.Pod, usingEviction bool) {
+			logger.Info("Removed pod from Node", "Pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "Evicted", usingEviction)
+		},
+		Out:    drainWriter{func(msg string) { logger.Info(msg) }},
+		ErrOut: drainWriter{func(msg string) { logger.Info(msg, "Stream", "stderr") }},
+	}
+
+	if err := drain.RunCordonOrUncordon(drainer, n, true); err != nil {
+		return fmt.Errorf("unable to cordon node: %w", err)
+	}
+	if err := drain.RunNodeDrain(drainer, n.Name); err != nil {
+		return fmt.Errorf("unable to drain node: %w", err)
+	}
+	return nil
+}
+
+// Delete the node, ignoring nodes that are already gone
+func (r *MachineReconciler) deleteNode(ctx context.Context, nodeName string) error {
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: nodeName}, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get node: %v", err)
+	}
+	if err := r.Client.Delete(ctx, n); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete node: %w", err)
+	}
+	return nil
+}
+
+// Emit an event on the machine if a recorder is configured
+func (r *MachineReconciler) recordEvent(m *machinev1.Machine, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(m, eventType, reason, messageFmt, args...)
+}
+
+// drainWriter passes output from the drain helper to the logger
+type drainWriter struct {
+	logFunc func(string)
+}
+
+func (w drainWriter) Write(p []byte) (int, error) {
+	w.logFunc(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
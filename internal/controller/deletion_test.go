@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Machine deletion", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		NodeName         = "test-node"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		recorder   *record.FakeRecorder
+		rawMachine *machinev1.Machine
+		rawNode    *corev1.Node
+		kubeObjs   []runtime.Object
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		now := metav1.Now()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              MachineName,
+				Namespace:         MachineNamespace,
+				DeletionTimestamp: &now,
+				Finalizers:        []string{NodeCleanupFinalizer},
+				Annotations: map[string]string{
+					getAnnotationKey(InternalIPAnnotation): "1.2.3.4",
+				},
+			},
+			Status: machinev1.MachineStatus{
+				NodeRef: &corev1.ObjectReference{Kind: "Node", Name: NodeName},
+			},
+		}
+		rawNode = &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeName},
+		}
+		kubeObjs = []runtime.Object{rawNode}
+	})
+
+	newReconciler := func(objs ...client.Object) {
+		r = newFakeMachineReconciler(objs...)
+		r.DeleteNodes = true
+		r.DrainTimeout = time.Hour
+		r.KubeClient = kubefake.NewSimpleClientset(kubeObjs...)
+		r.GuardControlPlane = true
+		recorder = r.Recorder.(*record.FakeRecorder)
+	}
+
+	It("Should drain and delete the node then release the finalizer", func() {
+		newReconciler(rawMachine, rawNode)
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, types.NamespacedName{Name: NodeName}, &corev1.Node{}))).Should(BeTrue())
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, lookupKey, &machinev1.Machine{}))).Should(BeTrue())
+		Expect(recorder.Events).Should(Receive(ContainSubstring("DrainProceeds")))
+	})
+
+	It("Should skip draining when the exclude annotation is set", func() {
+		rawMachine.Annotations[ExcludeNodeDrainingAnnotation] = ""
+		rawMachine.Finalizers = append(rawMachine.Finalizers, "test.io/hold")
+		newReconciler(rawMachine, rawNode)
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Finalizers).ShouldNot(ContainElement(NodeCleanupFinalizer))
+		drained := conditions.Get(m, machinev1.MachineDrained)
+		Expect(drained).ShouldNot(BeNil())
+		Expect(drained.Message).Should(Equal("Node drain skipped"))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("DrainSkipped")))
+	})
+
+	It("Should not delete a control plane node while etcd would lose quorum", func() {
+		etcdPod := func(node string, ready corev1.ConditionStatus) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "etcd-" + node,
+					Namespace: etcdNamespace,
+					Labels:    map[string]string{"app": "etcd"},
+				},
+				Spec: corev1.PodSpec{NodeName: node},
+				Status: corev1.PodStatus{
+					Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+				},
+			}
+		}
+		rawMachine.Labels = map[string]string{MachineRoleLabel: "master"}
+		kubeObjs = append(kubeObjs,
+			etcdPod(NodeName, corev1.ConditionTrue),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionFalse),
+		)
+		newReconciler(rawMachine, rawNode)
+
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(etcdGuardRequeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionEtcdQuorumSafe)).Should(HaveField("Status", corev1.ConditionFalse))
+		Expect(conditions.Get(m, machinev1.MachineDrained)).Should(BeNil())
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName}, &corev1.Node{})).Should(Succeed())
+	})
+
+	It("Should hold a control plane machine without --delete-nodes while etcd would lose quorum", func() {
+		rawMachine.Labels = map[string]string{MachineRoleLabel: "master"}
+		kubeObjs = append(kubeObjs, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-" + NodeName, Namespace: etcdNamespace, Labels: map[string]string{"app": "etcd"}},
+			Spec:       corev1.PodSpec{NodeName: NodeName},
+		})
+		newReconciler(rawMachine, rawNode)
+		r.DeleteNodes = false
+
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(etcdGuardRequeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Finalizers).Should(ContainElement(NodeCleanupFinalizer))
+		Expect(conditions.Get(m, conditionEtcdQuorumSafe)).Should(HaveField("Status", corev1.ConditionFalse))
+	})
+
+	It("Should add the finalizer to control plane machines when only the guard is enabled", func() {
+		rawMachine.DeletionTimestamp = nil
+		rawMachine.Finalizers = nil
+		rawMachine.Labels = map[string]string{MachineRoleLabel: "master"}
+		newReconciler(rawMachine, rawNode)
+		r.DeleteNodes = false
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Finalizers).Should(ContainElement(NodeCleanupFinalizer))
+	})
+
+	It("Should wait for lifecycle hooks before draining and deleting the node", func() {
+		rawMachine.Spec.LifecycleHooks = machinev1.LifecycleHooks{
+			PreDrain:     []machinev1.LifecycleHook{{Name: "drain-hook", Owner: "test"}},
+			PreTerminate: []machinev1.LifecycleHook{{Name: "terminate-hook", Owner: "test"}},
+		}
+		newReconciler(rawMachine, rawNode)
+
+		By("Blocking the drain while the pre-drain hook exists")
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, machinev1.MachineDrainable)).Should(HaveField("Status", corev1.ConditionFalse))
+		Expect(conditions.Get(m, machinev1.MachineDrained)).Should(BeNil())
+
+		By("Draining but not deleting the node while the pre-terminate hook exists")
+		m.Spec.LifecycleHooks.PreDrain = nil
+		Expect(r.Client.Update(ctx, m)).Should(Succeed())
+		_, err = reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, machinev1.MachineDrained)).Should(HaveField("Status", corev1.ConditionTrue))
+		Expect(conditions.Get(m, machinev1.MachineTerminable)).Should(HaveField("Status", corev1.ConditionFalse))
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName}, &corev1.Node{})).Should(Succeed())
+
+		By("Deleting the node once the hooks are removed")
+		m.Spec.LifecycleHooks.PreTerminate = nil
+		Expect(r.Client.Update(ctx, m)).Should(Succeed())
+		_, err = reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, types.NamespacedName{Name: NodeName}, &corev1.Node{}))).Should(BeTrue())
+	})
+})
@@ -0,0 +1,138 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Role label set on machines by the installer and MachineSets
+	MachineRoleLabel = "machine.openshift.io/cluster-api-machine-role"
+
+	// Where the cluster-etcd-operator runs the etcd static pods and publishes membership
+	etcdNamespace          = "openshift-etcd"
+	etcdPodLabelSelector   = "app=etcd"
+	etcdEndpointsConfigMap = "etcd-endpoints"
+
+	// Machine condition reporting whether removing the machine would break etcd quorum
+	conditionEtcdQuorumSafe machinev1.ConditionType = "EtcdQuorumSafe"
+	reasonEtcdQuorumAtRisk                          = "EtcdQuorumAtRisk"
+
+	etcdGuardRequeueAfter = 30 * time.Second
+)
+
+// Machine has a control plane role label
+func isControlPlaneMachine(m *machinev1.Machine) bool {
+	switch m.Labels[MachineRoleLabel] {
+	case "master", "control-plane":
+		return true
+	}
+	return false
+}
+
+// Check that etcd keeps quorum without the member running on nodeName
+// Returns nil when there is no etcd membership to protect
+func (r *MachineReconciler) checkEtcdQuorum(ctx context.Context, nodeName string) error {
+	pods, err := r.KubeClient.CoreV1().Pods(etcdNamespace).List(ctx, metav1.ListOptions{LabelSelector: etcdPodLabelSelector})
+	if err != nil {
+		return fmt.Errorf("unable to list etcd pods: %w", err)
+	}
+
+	members := len(pods.Items)
+	cm, err := r.KubeClient.CoreV1().ConfigMaps(etcdNamespace).Get(ctx, etcdEndpointsConfigMap, metav1.GetOptions{})
+	switch {
+	case err == nil && len(cm.Data) > 0:
+		members = len(cm.Data)
+	case err != nil && !apierrors.IsNotFound(err):
+		return fmt.Errorf("unable to get etcd endpoints: %w", err)
+	}
+	if members == 0 {
+		return nil
+	}
+
+	healthy := 0
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != nodeName && isPodReady(&pod) {
+			healthy++
+		}
+	}
+	if quorum := members/2 + 1; healthy < quorum {
+		return fmt.Errorf("removing node %q leaves %d of %d etcd members healthy, quorum requires %d", nodeName, healthy, members, quorum)
+	}
+	return nil
+}
+
+// Block an action on a control plane machine that would drop etcd below quorum
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) guardEtcdQuorum(ctx context.Context, m *machinev1.Machine, action string) (ctrl.Result, error) {
+	if !r.GuardControlPlane || !isControlPlaneMachine(m) || m.Status.NodeRef == nil {
+		return ctrl.Result{}, nil
+	}
+
+	logger := log.FromContext(ctx)
+	original := conditions.Get(m, conditionEtcdQuorumSafe)
+	quorumErr := r.checkEtcdQuorum(ctx, m.Status.NodeRef.Name)
+	if quorumErr != nil {
+		logger.Info("Blocked by etcd quorum guard", "Action", action, "Reason", quorumErr.Error())
+		conditions.MarkFalse(m, conditionEtcdQuorumSafe, reasonEtcdQuorumAtRisk, machinev1.ConditionSeverityWarning, "%s blocked: %v", action, quorumErr)
+		r.recordEvent(m, corev1.EventTypeWarning, "EtcdQuorumAtRisk", "%s blocked: %v", action, quorumErr)
+	} else {
+		conditions.MarkTrue(m, conditionEtcdQuorumSafe)
+	}
+
+	if updated := conditions.Get(m, conditionEtcdQuorumSafe); original == nil || original.Status != updated.Status || original.Message != updated.Message {
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+		if quorumErr == nil {
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+	if quorumErr != nil {
+		return ctrl.Result{RequeueAfter: etcdGuardRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
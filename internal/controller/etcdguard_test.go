@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Etcd quorum guard", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		NodeName         = "test-node"
+	)
+
+	var (
+		ctx        context.Context
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	etcdPod := func(node string, ready corev1.ConditionStatus) runtime.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "etcd-" + node,
+				Namespace: etcdNamespace,
+				Labels:    map[string]string{"app": "etcd"},
+			},
+			Spec: corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+
+	etcdEndpoints := func(members int) runtime.Object {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: etcdEndpointsConfigMap, Namespace: etcdNamespace},
+			Data:       map[string]string{},
+		}
+		for i := 0; i < members; i++ {
+			cm.Data[string(rune('a'+i))] = "10.0.0.1"
+		}
+		return cm
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				Labels:    map[string]string{MachineRoleLabel: "master"},
+			},
+			Status: machinev1.MachineStatus{
+				NodeRef: &corev1.ObjectReference{Kind: "Node", Name: NodeName},
+			},
+		}
+	})
+
+	DescribeTable("Checking the etcd members left without the node",
+		func(quorumKept bool, kubeObjs ...runtime.Object) {
+			r := newFakeMachineReconciler()
+			r.KubeClient = kubefake.NewSimpleClientset(kubeObjs...)
+
+			err := r.checkEtcdQuorum(ctx, NodeName)
+			if quorumKept {
+				Expect(err).ShouldNot(HaveOccurred())
+			} else {
+				Expect(err).Should(MatchError(ContainSubstring("quorum requires")))
+			}
+		},
+		Entry("without etcd members", true),
+		Entry("with all other members ready", true,
+			etcdPod(NodeName, corev1.ConditionTrue),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionTrue),
+		),
+		Entry("with another member not ready", false,
+			etcdPod(NodeName, corev1.ConditionTrue),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionFalse),
+		),
+		Entry("with more endpoints than etcd pods", false,
+			etcdEndpoints(5),
+			etcdPod(NodeName, corev1.ConditionTrue),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionTrue),
+		),
+		Entry("with the endpoints matching ready etcd pods", true,
+			etcdEndpoints(3),
+			etcdPod(NodeName, corev1.ConditionFalse),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionTrue),
+		),
+	)
+
+	It("Should block the action and report it until quorum is safe", func() {
+		r := newFakeMachineReconciler(rawMachine)
+		r.GuardControlPlane = true
+		r.KubeClient = kubefake.NewSimpleClientset(
+			etcdEndpoints(3),
+			etcdPod(NodeName, corev1.ConditionTrue),
+			etcdPod("other-1", corev1.ConditionTrue),
+			etcdPod("other-2", corev1.ConditionFalse),
+		)
+		recorder := r.Recorder.(*record.FakeRecorder)
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		res, err := r.guardEtcdQuorum(ctx, m, "Test action")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(etcdGuardRequeueAfter))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("EtcdQuorumAtRisk")))
+
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		safe := conditions.Get(m, conditionEtcdQuorumSafe)
+		Expect(safe).Should(HaveField("Status", corev1.ConditionFalse))
+		Expect(safe.Message).Should(HavePrefix("Test action blocked"))
+
+		By("Letting the action proceed once the other member is ready")
+		_, err = r.KubeClient.CoreV1().Pods(etcdNamespace).UpdateStatus(ctx, etcdPod("other-2", corev1.ConditionTrue).(*corev1.Pod), metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		res, err = r.guardEtcdQuorum(ctx, m, "Test action")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.Requeue).Should(BeTrue())
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionEtcdQuorumSafe)).Should(HaveField("Status", corev1.ConditionTrue))
+
+		res, err = r.guardEtcdQuorum(ctx, m, "Test action")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.IsZero()).Should(BeTrue())
+	})
+
+	It("Should not guard worker machines", func() {
+		rawMachine.Labels[MachineRoleLabel] = "worker"
+		r := newFakeMachineReconciler(rawMachine)
+		r.GuardControlPlane = true
+		r.KubeClient = kubefake.NewSimpleClientset(etcdPod(NodeName, corev1.ConditionTrue))
+
+		res, err := r.guardEtcdQuorum(ctx, rawMachine, "Test action")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.IsZero()).Should(BeTrue())
+		Expect(conditions.Get(rawMachine, conditionEtcdQuorumSafe)).Should(BeNil())
+	})
+})
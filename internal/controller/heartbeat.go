@@ -0,0 +1,93 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// Instance states derived from the heartbeat Lease of a machine
+	InstanceStateBooting     = "booting"
+	InstanceStateRunning     = "running"
+	InstanceStateUnreachable = "unreachable"
+
+	// Heartbeat Leases are only read, cached and watched in the namespace of the machines
+	HeartbeatNamespace = machineNamespace
+)
+
+// Derive the instance state from the Lease named after the machine in its namespace
+// Returns an empty state when heartbeats are disabled or the machine has no Lease,
+// and how long until a fresh Lease turns stale
+func (r *MachineReconciler) heartbeatState(ctx context.Context, m *machinev1.Machine) (string, time.Duration, error) {
+	if !r.HeartbeatLeases || m.Namespace != HeartbeatNamespace {
+		return "", 0, nil
+	}
+	lease := &coordinationv1.Lease{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: m.Name, Namespace: m.Namespace}, lease); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", 0, nil
+		}
+		return "", 0, fmt.Errorf("unable to get lease: %w", err)
+	}
+
+	renewed := lease.CreationTimestamp.Time
+	if lease.Spec.AcquireTime != nil {
+		renewed = lease.Spec.AcquireTime.Time
+	}
+	if lease.Spec.RenewTime != nil {
+		renewed = lease.Spec.RenewTime.Time
+	}
+	timeout := r.HeartbeatTimeout
+	if lease.Spec.LeaseDurationSeconds != nil && *lease.Spec.LeaseDurationSeconds > 0 {
+		timeout = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+
+	remaining := time.Until(renewed.Add(timeout))
+	if remaining <= 0 {
+		return InstanceStateUnreachable, 0, nil
+	}
+	// Check again just after the lease expires
+	remaining += time.Second
+	if m.Status.NodeRef == nil {
+		return InstanceStateBooting, remaining, nil
+	}
+	return InstanceStateRunning, remaining, nil
+}
+
+// Enqueue the machine named after the lease
+func leaseToMachine(_ context.Context, o client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: apitypes.NamespacedName{Name: o.GetName(), Namespace: o.GetNamespace()}}}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Heartbeat leases", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				Annotations: map[string]string{
+					getAnnotationKey(InternalIPAnnotation):    "10.0.0.5",
+					getAnnotationKey(ProviderStateAnnotation): "unknown",
+				},
+			},
+		}
+	})
+
+	newLease := func(renewed time.Time) *coordinationv1.Lease {
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: MachineName, Namespace: MachineNamespace},
+			Spec: coordinationv1.LeaseSpec{
+				RenewTime:            &renewTime,
+				LeaseDurationSeconds: ptr.To[int32](40),
+			},
+		}
+	}
+
+	reconcile := func(lease *coordinationv1.Lease) (string, ctrl.Result) {
+		objs := []client.Object{rawMachine}
+		if lease != nil {
+			objs = append(objs, lease)
+		}
+		r = newFakeMachineReconciler(objs...)
+		r.HeartbeatLeases = true
+		r.HeartbeatTimeout = 2 * time.Minute
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ps.InstanceState).ShouldNot(BeNil())
+		return *ps.InstanceState, res
+	}
+
+	It("Should use the annotation without a lease", func() {
+		state, res := reconcile(nil)
+		Expect(state).Should(Equal("unknown"))
+		Expect(res.RequeueAfter).Should(BeZero())
+	})
+
+	It("Should report a fresh lease of an unlinked machine as booting", func() {
+		state, res := reconcile(newLease(time.Now()))
+		Expect(state).Should(Equal(InstanceStateBooting))
+		Expect(res.RequeueAfter).Should(BeNumerically("~", 41*time.Second, 2*time.Second))
+	})
+
+	It("Should report a fresh lease of a linked machine as running", func() {
+		rawMachine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "test-node"}
+		state, _ := reconcile(newLease(time.Now()))
+		Expect(state).Should(Equal(InstanceStateRunning))
+	})
+
+	It("Should ignore leases outside the machine namespace", func() {
+		r = newFakeMachineReconciler()
+		r.HeartbeatLeases = true
+		m := rawMachine.DeepCopy()
+		m.Namespace = "other"
+		state, requeue, err := r.heartbeatState(ctx, m)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(state).Should(BeEmpty())
+		Expect(requeue).Should(BeZero())
+	})
+
+	It("Should report a stale lease as unreachable", func() {
+		state, res := reconcile(newLease(time.Now().Add(-time.Minute)))
+		Expect(state).Should(Equal(InstanceStateUnreachable))
+		Expect(res.RequeueAfter).Should(BeZero())
+	})
+})
@@ -0,0 +1,282 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+const (
+	// Name of the Host claimed by the machine, in the namespace of the machine
+	HostAnnotation = "host"
+
+	// Machine condition reporting whether a Host was claimed from a HostPool
+	conditionHostClaimed  machinev1.ConditionType = "HostClaimed"
+	reasonNoHostAvailable                         = "NoHostAvailable"
+
+	hostClaimRequeueAfter = 30 * time.Second
+)
+
+// Machine holds a Host from a HostPool
+func hasHostClaim(m *machinev1.Machine) bool {
+	_, ok := m.Annotations[getAnnotationKey(HostAnnotation)]
+	return ok
+}
+
+// Claim a free Host for a machine matched by a HostPool and write the host to the machine annotations
+// A machine with MAC addresses but no address annotations claims the Host with one of its MAC addresses instead
+// Machines that are already annotated or linked are left alone
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcileHostClaim(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	macs := machineMACAddresses(m)
+	lookupByMAC := len(macs) > 0 && !hasAddressAnnotations(m) && !hasBareMetalHost(m)
+	if !r.HostPools || hasHostClaim(m) || m.Status.NodeRef != nil || (hasLinkerAnnotations(m) && !lookupByMAC) {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx)
+
+	var host *inventoryv1alpha1.Host
+	var err error
+	source := ""
+	if lookupByMAC {
+		source = fmt.Sprintf("with MAC addresses %s", strings.Join(macs, ","))
+		host, err = r.claimHostByMAC(ctx, m, macs)
+	} else {
+		pool, poolErr := r.hostPoolForMachine(ctx, m)
+		if poolErr != nil || pool == nil {
+			return ctrl.Result{}, poolErr
+		}
+		source = fmt.Sprintf("in pool %q", pool.Name)
+		host, err = r.claimHost(ctx, m, pool)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if host == nil {
+		original := conditions.Get(m, conditionHostClaimed)
+		conditions.MarkFalse(m, conditionHostClaimed, reasonNoHostAvailable, machinev1.ConditionSeverityWarning, "No host available %s", source)
+		if original == nil || original.Reason != reasonNoHostAvailable {
+			r.recordEvent(m, corev1.EventTypeWarning, "NoHostAvailable", "No host available %s", source)
+			if err := r.Client.Status().Update(ctx, m); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+			}
+		}
+		if lookupByMAC {
+			// The machine can still be linked to a node reporting its MAC addresses
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{RequeueAfter: hostClaimRequeueAfter}, nil
+	}
+
+	res := &provision.Result{Addresses: host.Spec.Addresses}
+	if host.Spec.ProviderID != "" {
+		res.ProviderID = &host.Spec.ProviderID
+	}
+	applyProvisionResult(m, res)
+	m.Annotations[getAnnotationKey(HostAnnotation)] = host.Name
+	if len(macs) == 0 && len(host.Spec.MACAddresses) > 0 {
+		if hostMACs, err := parseMACAddresses(strings.Join(host.Spec.MACAddresses, ",")); err == nil {
+			m.Annotations[getAnnotationKey(MACAddressAnnotation)] = strings.Join(hostMACs, ",")
+		}
+	}
+	logger.Info("Claimed host", "Host", host.Name, "HostPool", host.Spec.PoolName)
+	if err := r.Client.Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+
+	conditions.MarkTrue(m, conditionHostClaimed)
+	r.recordEvent(m, corev1.EventTypeNormal, "HostClaimed", "Claimed host %q %s", host.Name, source)
+	if err := r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Find the first HostPool, by name, whose machine selector matches the machine
+func (r *MachineReconciler) hostPoolForMachine(ctx context.Context, m *machinev1.Machine) (*inventoryv1alpha1.HostPool, error) {
+	pools := &inventoryv1alpha1.HostPoolList{}
+	if err := r.Client.List(ctx, pools, client.InNamespace(m.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list host pools: %w", err)
+	}
+	sort.Slice(pools.Items, func(i, j int) bool { return pools.Items[i].Name < pools.Items[j].Name })
+	for i := range pools.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&pools.Items[i].Spec.MachineSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid machine selector in host pool %q: %w", pools.Items[i].Name, err)
+		}
+		if !selector.Empty() && selector.Matches(labels.Set(m.Labels)) {
+			return &pools.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Claim a host of the pool for the machine, returning the host already claimed by it if any
+// Returns nil when the pool has no free host
+func (r *MachineReconciler) claimHost(ctx context.Context, m *machinev1.Machine, pool *inventoryv1alpha1.HostPool) (*inventoryv1alpha1.Host, error) {
+	hosts := &inventoryv1alpha1.HostList{}
+	if err := r.Client.List(ctx, hosts, client.InNamespace(pool.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list hosts: %w", err)
+	}
+	sort.Slice(hosts.Items, func(i, j int) bool { return hosts.Items[i].Name < hosts.Items[j].Name })
+
+	// A previous claim may have been stored before the machine could be updated
+	for i := range hosts.Items {
+		if ref := hosts.Items[i].Status.MachineRef; ref != nil && ref.UID == m.UID {
+			return &hosts.Items[i], nil
+		}
+	}
+
+	for i := range hosts.Items {
+		host := &hosts.Items[i]
+		if host.Spec.PoolName != pool.Name || !host.Claimable() {
+			continue
+		}
+		return host, r.claimHostFor(ctx, m, host)
+	}
+	return nil, nil
+}
+
+// Claim the host in the namespace of the machine that has one of its MAC addresses, returning the host already claimed by it if any
+// Returns nil when no such host is free
+func (r *MachineReconciler) claimHostByMAC(ctx context.Context, m *machinev1.Machine, macs []string) (*inventoryv1alpha1.Host, error) {
+	hosts := &inventoryv1alpha1.HostList{}
+	if err := r.Client.List(ctx, hosts, client.InNamespace(m.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list hosts: %w", err)
+	}
+	sort.Slice(hosts.Items, func(i, j int) bool { return hosts.Items[i].Name < hosts.Items[j].Name })
+	for i := range hosts.Items {
+		host := &hosts.Items[i]
+		hostMACs, err := parseMACAddresses(strings.Join(host.Spec.MACAddresses, ","))
+		if err != nil || !macAddressesMatch(macs, hostMACs) {
+			continue
+		}
+		if ref := host.Status.MachineRef; ref != nil && ref.UID == m.UID {
+			return host, nil
+		}
+		if !host.Claimable() {
+			continue
+		}
+		return host, r.claimHostFor(ctx, m, host)
+	}
+	return nil, nil
+}
+
+// Record the machine as the claimant of the host
+func (r *MachineReconciler) claimHostFor(ctx context.Context, m *machinev1.Machine, host *inventoryv1alpha1.Host) error {
+	now := metav1.Now()
+	host.Status.State = inventoryv1alpha1.HostStateClaimed
+	host.Status.MachineRef = &corev1.ObjectReference{
+		APIVersion: machinev1.GroupVersion.String(),
+		Kind:       "Machine",
+		Namespace:  m.Namespace,
+		Name:       m.Name,
+		UID:        m.UID,
+	}
+	host.Status.ClaimedAt = &now
+	// The resourceVersion check makes the claim fail if another machine claimed the host first
+	if err := r.Client.Status().Update(ctx, host); err != nil {
+		return fmt.Errorf("unable to claim host %q: %w", host.Name, err)
+	}
+	return nil
+}
+
+// Release the host claimed by a deleted machine
+func (r *MachineReconciler) releaseHost(ctx context.Context, m *machinev1.Machine) error {
+	host, err := r.claimedHost(ctx, m)
+	if err != nil || host == nil {
+		return err
+	}
+	if err := releaseHostClaim(ctx, r.Client, host); err != nil {
+		return err
+	}
+	r.recordEvent(m, corev1.EventTypeNormal, "HostReleased", "Released host %q", host.Name)
+	return nil
+}
+
+// Find the host claimed by the machine, from its annotation or else from the claim itself
+// A host claimed by MAC address has no HostPool to release it, and the machine may be deleted before the annotation is written
+// Returns nil when the machine holds no host
+func (r *MachineReconciler) claimedHost(ctx context.Context, m *machinev1.Machine) (*inventoryv1alpha1.Host, error) {
+	if name, ok := m.Annotations[getAnnotationKey(HostAnnotation)]; ok {
+		host := &inventoryv1alpha1.Host{}
+		if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: name, Namespace: m.Namespace}, host); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unable to get host: %w", err)
+		}
+		if ref := host.Status.MachineRef; ref != nil && ref.UID == m.UID {
+			return host, nil
+		}
+	}
+	if !r.HostPools {
+		return nil, nil
+	}
+
+	hosts := &inventoryv1alpha1.HostList{}
+	if err := r.Client.List(ctx, hosts, client.InNamespace(m.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list hosts: %w", err)
+	}
+	for i := range hosts.Items {
+		if ref := hosts.Items[i].Status.MachineRef; ref != nil && ref.UID == m.UID {
+			return &hosts.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Clear the claim of a host so it can be claimed again
+func releaseHostClaim(ctx context.Context, c client.Client, host *inventoryv1alpha1.Host) error {
+	host.Status.MachineRef = nil
+	host.Status.ClaimedAt = nil
+	host.Status.State = inventoryv1alpha1.HostStateAvailable
+	if host.Spec.Disabled {
+		host.Status.State = inventoryv1alpha1.HostStateDisabled
+	}
+	if err := c.Status().Update(ctx, host); err != nil {
+		return fmt.Errorf("unable to release host %q: %w", host.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Host pools", func() {
+
+	const (
+		MachineName      = "worker-abcde"
+		MachineNamespace = "openshift-machine-api"
+		MachineSetLabel  = "machine.openshift.io/cluster-api-machineset"
+		PoolName         = "baremetal"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		rawMachine *machinev1.Machine
+		pool       *inventoryv1alpha1.HostPool
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	newHost := func(name, ip string) *inventoryv1alpha1.Host {
+		return &inventoryv1alpha1.Host{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: MachineNamespace},
+			Spec: inventoryv1alpha1.HostSpec{
+				PoolName:   PoolName,
+				Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}},
+				ProviderID: "baremetal:///" + name,
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				UID:       "machine-uid",
+				Labels:    map[string]string{MachineSetLabel: "worker"},
+			},
+		}
+		pool = &inventoryv1alpha1.HostPool{
+			ObjectMeta: metav1.ObjectMeta{Name: PoolName, Namespace: MachineNamespace},
+			Spec: inventoryv1alpha1.HostPoolSpec{
+				MachineSelector: metav1.LabelSelector{MatchLabels: map[string]string{MachineSetLabel: "worker"}},
+			},
+		}
+	})
+
+	newReconciler := func(objs ...client.Object) {
+		r = newFakeMachineReconciler(objs...)
+		r.HostPools = true
+	}
+
+	It("Should claim a free host and release it when the machine is deleted", func() {
+		claimed := newHost("host-0", "10.0.1.10")
+		claimed.Status.MachineRef = &corev1.ObjectReference{Name: "other", Namespace: MachineNamespace, UID: "other-uid"}
+		disabled := newHost("host-1", "10.0.1.11")
+		disabled.Spec.Disabled = true
+		newReconciler(rawMachine, pool, claimed, disabled, newHost("host-2", "10.0.1.12"))
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(HostAnnotation), "host-2"))
+		Expect(m.Spec.ProviderID).Should(HaveValue(Equal("baremetal:///host-2")))
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.1.12"}))
+		Expect(m.Finalizers).Should(ContainElement(NodeCleanupFinalizer))
+		Expect(conditions.Get(m, conditionHostClaimed)).Should(HaveField("Status", corev1.ConditionTrue))
+
+		host := &inventoryv1alpha1.Host{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: "host-2", Namespace: MachineNamespace}, host)).Should(Succeed())
+		Expect(host.Status.State).Should(Equal(inventoryv1alpha1.HostStateClaimed))
+		Expect(host.Status.MachineRef).Should(HaveField("UID", m.UID))
+
+		By("Releasing the host on deletion")
+		Expect(r.Client.Delete(ctx, m)).Should(Succeed())
+		_, err = reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, lookupKey, m))).Should(BeTrue())
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: "host-2", Namespace: MachineNamespace}, host)).Should(Succeed())
+		Expect(host.Status.MachineRef).Should(BeNil())
+		Expect(host.Status.State).Should(Equal(inventoryv1alpha1.HostStateAvailable))
+	})
+
+	It("Should release a host claimed by MAC address without a HostPool", func() {
+		rawMachine.Labels = nil
+		rawMachine.Annotations = map[string]string{getAnnotationKey(MACAddressAnnotation): "52:54:00:00:00:01"}
+		host := newHost("host-0", "10.0.1.10")
+		host.Spec.PoolName = ""
+		host.Spec.MACAddresses = []string{"52:54:00:00:00:01"}
+		newReconciler(rawMachine, host)
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(HostAnnotation), "host-0"))
+		Expect(m.Finalizers).Should(ContainElement(NodeCleanupFinalizer))
+
+		By("Releasing the claim even when the host annotation is missing")
+		delete(m.Annotations, getAnnotationKey(HostAnnotation))
+		Expect(r.Client.Update(ctx, m)).Should(Succeed())
+		Expect(r.Client.Delete(ctx, m)).Should(Succeed())
+		_, err = reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, lookupKey, m))).Should(BeTrue())
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(host), host)).Should(Succeed())
+		Expect(host.Status.MachineRef).Should(BeNil())
+		Expect(host.Status.State).Should(Equal(inventoryv1alpha1.HostStateAvailable))
+	})
+
+	It("Should wait when the pool has no free host", func() {
+		newReconciler(rawMachine, pool)
+
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(hostClaimRequeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionHostClaimed)).Should(HaveField("Reason", reasonNoHostAvailable))
+	})
+
+	It("Should count hosts and release claims of missing machines", func() {
+		orphan := newHost("host-0", "10.0.1.10")
+		orphan.Status.MachineRef = &corev1.ObjectReference{Name: "gone", Namespace: MachineNamespace, UID: "gone-uid"}
+		newReconciler(pool, orphan, newHost("host-1", "10.0.1.11"))
+		pr := &HostPoolReconciler{Client: r.Client, Scheme: scheme.Scheme}
+
+		_, err := pr.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: PoolName, Namespace: MachineNamespace}})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(pool), pool)).Should(Succeed())
+		Expect(pool.Status).Should(Equal(inventoryv1alpha1.HostPoolStatus{Hosts: 2, Available: 2}))
+	})
+	It("Should map a machine to the pool of its host", func() {
+		rawMachine.Annotations = map[string]string{getAnnotationKey(HostAnnotation): "host-0"}
+		newReconciler(rawMachine, pool, newHost("host-0", "10.0.1.10"))
+		pr := &HostPoolReconciler{Client: r.Client, Scheme: scheme.Scheme}
+
+		Expect(pr.machineToHostPool(ctx, rawMachine)).Should(ConsistOf(
+			ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pool)},
+		))
+		Expect(pr.machineToHostPool(ctx, &machinev1.Machine{})).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,160 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+)
+
+// HostPoolReconciler keeps the state of the hosts of a HostPool and the pool counts up to date
+type HostPoolReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hostpools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hostpools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hosts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hosts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch
+func (r *HostPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	pool := &inventoryv1alpha1.HostPool{}
+	if err := r.Client.Get(ctx, req.NamespacedName, pool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get host pool: %v", err)
+	}
+
+	hosts := &inventoryv1alpha1.HostList{}
+	if err := r.Client.List(ctx, hosts, client.InNamespace(pool.Namespace)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to list hosts: %w", err)
+	}
+
+	status := inventoryv1alpha1.HostPoolStatus{}
+	for i := range hosts.Items {
+		host := &hosts.Items[i]
+		if host.Spec.PoolName != pool.Name {
+			continue
+		}
+		if err := r.syncHost(ctx, host); err != nil {
+			return ctrl.Result{}, err
+		}
+		status.Hosts++
+		switch {
+		case host.Status.MachineRef != nil:
+			status.Claimed++
+		case host.Claimable():
+			status.Available++
+		}
+	}
+
+	if !reflect.DeepEqual(pool.Status, status) {
+		pool.Status = status
+		logger.Info("New HostPool Status", "Status", pool.Status)
+		if err := r.Client.Status().Update(ctx, pool); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// Release claims held by machines that no longer exist and set the state of unclaimed hosts
+func (r *HostPoolReconciler) syncHost(ctx context.Context, host *inventoryv1alpha1.Host) error {
+	if ref := host.Status.MachineRef; ref != nil {
+		m := &machinev1.Machine{}
+		err := r.Client.Get(ctx, apitypes.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, m)
+		switch {
+		case err == nil && m.UID == ref.UID:
+			return nil
+		case err != nil && !apierrors.IsNotFound(err):
+			return fmt.Errorf("unable to get machine: %w", err)
+		}
+		log.FromContext(ctx).Info("Releasing host of missing machine", "Host", host.Name, "Machine", ref.Name)
+		return releaseHostClaim(ctx, r.Client, host)
+	}
+
+	state := inventoryv1alpha1.HostStateAvailable
+	if host.Spec.Disabled {
+		state = inventoryv1alpha1.HostStateDisabled
+	}
+	if host.Status.State == state {
+		return nil
+	}
+	host.Status.State = state
+	if err := r.Client.Status().Update(ctx, host); err != nil {
+		return fmt.Errorf("unable to update host %q: %w", host.Name, err)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HostPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&inventoryv1alpha1.HostPool{}).
+		Watches(&inventoryv1alpha1.Host{}, handler.EnqueueRequestsFromMapFunc(hostToHostPool)).
+		Watches(&machinev1.Machine{}, handler.EnqueueRequestsFromMapFunc(r.machineToHostPool)).
+		Complete(r)
+}
+
+// Map a machine to the pool of the host it claimed, so deleting the machine releases the host
+func (r *HostPoolReconciler) machineToHostPool(ctx context.Context, o client.Object) []reconcile.Request {
+	name := o.GetAnnotations()[getAnnotationKey(HostAnnotation)]
+	if name == "" {
+		return nil
+	}
+	host := &inventoryv1alpha1.Host{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: name, Namespace: o.GetNamespace()}, host); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "unable to get host", "Host", name)
+		}
+		return nil
+	}
+	return hostToHostPool(ctx, host)
+}
+
+func hostToHostPool(_ context.Context, o client.Object) []reconcile.Request {
+	host, ok := o.(*inventoryv1alpha1.Host)
+	if !ok || host.Spec.PoolName == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: apitypes.NamespacedName{Name: host.Spec.PoolName, Namespace: host.Namespace}}}
+}
@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Field index of machines by the name of their linked node
+	machineNodeRefIndex = "status.nodeRef.name"
+	// Field index of machines by their node-name annotation
+	machineNodeNameIndex = "metadata.annotations.node-name"
+	// Field index of machines by their providerID
+	machineProviderIDIndex = "spec.providerID"
+	// Field index of unlinked machines without a node-name annotation by their MAC addresses
+	machineMACAddressIndex = "metadata.annotations.mac-address"
+)
+
+// IndexMachineFields registers the machine field indexes the controllers list machines by
+// Call it once for a manager, before the controllers are set up
+func IndexMachineFields(ctx context.Context, mgr ctrl.Manager) error {
+	indexes := map[string]client.IndexerFunc{
+		machineNodeRefIndex:    machineNodeRefName,
+		machineNodeNameIndex:   machineNodeName,
+		machineProviderIDIndex: machineProviderID,
+		machineMACAddressIndex: unlinkedMachineMACAddresses,
+	}
+	for field, extract := range indexes {
+		if err := mgr.GetFieldIndexer().IndexField(ctx, &machinev1.Machine{}, field, extract); err != nil {
+			return fmt.Errorf("unable to index machines by %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+func machineNodeRefName(o client.Object) []string {
+	m, ok := o.(*machinev1.Machine)
+	if !ok || m.Status.NodeRef == nil {
+		return nil
+	}
+	return []string{m.Status.NodeRef.Name}
+}
+
+func machineNodeName(o client.Object) []string {
+	if name := o.GetAnnotations()[getAnnotationKey(NodeNameAnnotation)]; name != "" {
+		return []string{name}
+	}
+	return nil
+}
+
+func machineProviderID(o client.Object) []string {
+	m, ok := o.(*machinev1.Machine)
+	if !ok || m.Spec.ProviderID == nil || *m.Spec.ProviderID == "" {
+		return nil
+	}
+	return []string{*m.Spec.ProviderID}
+}
+
+func unlinkedMachineMACAddresses(o client.Object) []string {
+	m, ok := o.(*machinev1.Machine)
+	if !ok || m.Status.NodeRef != nil || machineNodeName(m) != nil {
+		return nil
+	}
+	return machineMACAddresses(m)
+}
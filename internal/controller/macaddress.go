@@ -0,0 +1,144 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/annotations"
+)
+
+const (
+	// Comma separated MAC addresses of the machine interfaces
+	// Nodes report theirs with an annotation of the same key, or a label holding a single dash separated address
+	MACAddressAnnotation = annotations.MACAddress
+
+	reasonInvalidMACAddress   = "InvalidMACAddress"
+	reasonAmbiguousMACAddress = "AmbiguousMACAddress"
+)
+
+// Parse a comma separated list of MAC addresses into their canonical lower case, colon separated form
+func parseMACAddresses(value string) ([]string, error) {
+	var macs []string
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		hw, err := net.ParseMAC(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q: %w", field, err)
+		}
+		if mac := hw.String(); !seen[mac] {
+			seen[mac] = true
+			macs = append(macs, mac)
+		}
+	}
+	sort.Strings(macs)
+	return macs, nil
+}
+
+// MAC addresses of the machine, nil when the annotation is missing or invalid
+func machineMACAddresses(m *machinev1.Machine) []string {
+	macs, _ := parseMACAddresses(m.Annotations[getAnnotationKey(MACAddressAnnotation)])
+	return macs
+}
+
+// MAC addresses reported by the node label and annotation, invalid values are ignored
+func nodeMACAddresses(n *corev1.Node) []string {
+	var values []string
+	for _, v := range []string{n.Annotations[getAnnotationKey(MACAddressAnnotation)], n.Labels[getAnnotationKey(MACAddressAnnotation)]} {
+		if v == "" {
+			continue
+		}
+		if macs, err := parseMACAddresses(v); err == nil {
+			values = append(values, macs...)
+		}
+	}
+	return values
+}
+
+// The two sets of canonical MAC addresses share an address
+func macAddressesMatch(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Link an unlinked machine to the node reporting one of its MAC addresses
+// Machines with a node-name annotation are linked by name instead
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcileMACAddress(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	value, ok := m.Annotations[getAnnotationKey(MACAddressAnnotation)]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	macs, err := parseMACAddresses(value)
+	if err != nil {
+		log.FromContext(ctx).Info("Ignoring mac-address annotation", "Error", err.Error())
+		r.recordEvent(m, corev1.EventTypeWarning, reasonInvalidMACAddress, "%v", err)
+		return ctrl.Result{}, nil
+	}
+	if len(macs) == 0 || m.Status.NodeRef != nil || m.Annotations[getAnnotationKey(NodeNameAnnotation)] != "" {
+		return ctrl.Result{}, nil
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := r.Client.List(ctx, nodes); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to list nodes: %w", err)
+	}
+	var matches []*corev1.Node
+	for i := range nodes.Items {
+		if nodes.Items[i].DeletionTimestamp.IsZero() && macAddressesMatch(macs, nodeMACAddresses(&nodes.Items[i])) {
+			matches = append(matches, &nodes.Items[i])
+		}
+	}
+	switch len(matches) {
+	case 0:
+		// Linked once a node reports one of the addresses, see machinesForNode
+		return ctrl.Result{}, nil
+	case 1:
+		return r.linkNode(ctx, m, matches[0])
+	default:
+		r.recordEvent(m, corev1.EventTypeWarning, reasonAmbiguousMACAddress, "Nodes %s and %s report the MAC addresses of the machine", matches[0].Name, matches[1].Name)
+		return ctrl.Result{}, nil
+	}
+}
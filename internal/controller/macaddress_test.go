@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("MAC addresses", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		NodeName         = "test-node"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		recorder   *record.FakeRecorder
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				UID:       "machine-uid",
+				Annotations: map[string]string{
+					getAnnotationKey(MACAddressAnnotation): "52:54:00:AA:BB:01, 52-54-00-aa-bb-02",
+				},
+			},
+		}
+	})
+
+	reconcile := func(objs ...client.Object) {
+		r = newFakeMachineReconciler(append(objs, rawMachine)...)
+		r.HostPools = true
+		recorder = r.Recorder.(*record.FakeRecorder)
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+	}
+
+	getMachine := func() *machinev1.Machine {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		return m
+	}
+
+	It("Should parse and normalize MAC addresses", func() {
+		macs, err := parseMACAddresses("52-54-00-AA-BB-02,52:54:00:aa:bb:01,,52:54:00:aa:bb:01")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(macs).Should(Equal([]string{"52:54:00:aa:bb:01", "52:54:00:aa:bb:02"}))
+
+		_, err = parseMACAddresses("52:54:00:aa:bb")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Should record the MAC addresses in providerStatus", func() {
+		reconcile()
+		ps, err := providerStatusFromRawExtension(getMachine().Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ps.MACAddresses).Should(Equal([]string{"52:54:00:aa:bb:01", "52:54:00:aa:bb:02"}))
+	})
+
+	It("Should link the node reporting one of the MAC addresses", func() {
+		reconcile(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "other-node",
+				Annotations: map[string]string{getAnnotationKey(MACAddressAnnotation): "52:54:00:aa:bb:ff"},
+			}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   NodeName,
+				Labels: map[string]string{getAnnotationKey(MACAddressAnnotation): "52-54-00-aa-bb-02"},
+			}},
+		)
+		Expect(getMachine().Status.NodeRef).Should(HaveValue(HaveField("Name", NodeName)))
+	})
+
+	It("Should report an invalid annotation", func() {
+		rawMachine.Annotations[getAnnotationKey(MACAddressAnnotation)] = "not-a-mac"
+		reconcile()
+		Expect(getMachine().Status.NodeRef).Should(BeNil())
+		Expect(recorder.Events).Should(Receive(ContainSubstring(reasonInvalidMACAddress)))
+	})
+
+	It("Should claim the host with a matching MAC address", func() {
+		reconcile(
+			&inventoryv1alpha1.Host{
+				ObjectMeta: metav1.ObjectMeta{Name: "host-0", Namespace: MachineNamespace},
+				Spec: inventoryv1alpha1.HostSpec{
+					Addresses:    []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.1.10"}},
+					MACAddresses: []string{"52:54:00:aa:bb:ff"},
+				},
+			},
+			&inventoryv1alpha1.Host{
+				ObjectMeta: metav1.ObjectMeta{Name: "host-1", Namespace: MachineNamespace},
+				Spec: inventoryv1alpha1.HostSpec{
+					Addresses:    []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.1.11"}},
+					MACAddresses: []string{"52:54:00:AA:BB:01"},
+				},
+			},
+		)
+		m := getMachine()
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(HostAnnotation), "host-1"))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), "10.0.1.11"))
+	})
+})
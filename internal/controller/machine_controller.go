@@ -27,33 +27,48 @@ package controller
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
+	"slices"
 	"strings"
+	"text/template"
 	"time"
 
 	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-	kjson "sigs.k8s.io/json"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/annotations"
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
 )
 
 const (
 	requeueAfter            = 30 * time.Second
 	machineNamespace        = "openshift-machine-api"
-	AnnotationBase          = "machine-node-linker.github.com"
-	InternalIPAnnotation    = "internal-ip"
-	InternalDNSAnnotation   = "internal-dns"
-	HostnameAnnotation      = "hostname"
+	AnnotationBase          = annotations.Base
+	InternalIPAnnotation    = annotations.InternalIP
+	InternalDNSAnnotation   = annotations.InternalDNS
+	HostnameAnnotation      = annotations.Hostname
 	ProviderStateAnnotation = "provider-state"
+	InstanceIDAnnotation    = "instance-id"
+	ProviderIDAnnotation    = "provider-id"
 	PhaseAnnotation         = "manage-phase"
+	ManagedAnnotation       = "managed"
 
 	// This operator supports a subset of phase settings.
 	// When a noderef exists and points to a non-existent node
@@ -79,22 +94,74 @@ var (
 	myProviderName      = AnnotationBase
 )
 
-// Object for serializing providerstatus object in machine status
-// API defines it as a RawExtension
-type providerStatus struct {
-	InstanceState *string `json:"instanceState,omitempty"`
-	ProvidedBy    *string `json:"providedBy,omitempty"`
-}
-
 // MachineReconciler reconciles a Machine object
 type MachineReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Template used to build spec.providerID when the provider-id annotation is not set
+	ProviderIDTemplate *template.Template
+	// Copy spec.providerID to the linked node when the node does not have one
+	SetNodeProviderID bool
+	// Drain and delete the linked node when a managed machine is deleted
+	DeleteNodes bool
+	// Give up draining after this long and delete the node anyway, zero waits forever
+	DrainTimeout time.Duration
+	// Block deletion and failure of control plane machines that would break etcd quorum
+	GuardControlPlane bool
+	// Runs external create, reprovision and delete hooks, nil when not configured
+	Provisioner provision.Provisioner
+	// Claim a Host from a matching HostPool for machines without addresses
+	HostPools bool
+	// What to do when the linked node is replaced by a node with the same name, one of accept, fail or approve
+	NodeReplacementPolicy string
+	// Require approval of a replaced node that does not report the machine addresses
+	VerifyReplacedNodeAddresses bool
+	// Derive the instance state from a Lease named after the machine
+	HeartbeatLeases bool
+	// How long a Lease without leaseDurationSeconds stays fresh after its last renewal
+	HeartbeatTimeout time.Duration
+	// How often the power state is read from the BMC of machines with a bmc-address annotation
+	BMCPollInterval time.Duration
+	// Watch the BareMetalHosts referenced by machines instead of polling them, requires the metal3 CRDs
+	BareMetalHosts bool
+	// Base URL of a NetBox instance providing addresses, NetBox is not used when empty
+	NetBoxURL string
+	// Secret with the NetBox API token
+	NetBoxTokenSecret apitypes.NamespacedName
+	// How long a NetBox lookup is reused
+	NetBoxCacheTTL time.Duration
+	// ConfigMap or Secret mapping machine names, hostnames or MAC addresses to addresses, nil when not configured
+	AddressInventory *AddressInventory
+	// Which source wins when the annotations and the address inventory provide the same address type, annotations or inventory
+	AddressInventoryPrecedence string
+
+	KubeClient kubernetes.Interface
+	Recorder   record.EventRecorder
+
+	bmcCache    bmcPowerCache
+	netboxCache netboxCache
+	// Cached reads of BareMetalHosts, the Client is used when unset
+	bareMetalHostReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=machine.openshift.io,resources=machines/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=machine.openshift.io,resources=machines/finalizers,verbs=update
+// +kubebuilder:rbac:groups=,resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups=,resources=pods/eviction,verbs=create
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get
+// +kubebuilder:rbac:groups=,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=,namespace=openshift-etcd,resources=configmaps,resourceNames=etcd-endpoints,verbs=get
+// +kubebuilder:rbac:groups=,namespace=machine-node-linker,resources=configmaps;secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=,resources=nodes,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;create;delete
+// +kubebuilder:rbac:groups=,namespace=openshift-machine-api,resources=secrets,verbs=get
+// +kubebuilder:rbac:groups=coordination.k8s.io,namespace=openshift-machine-api,resources=leases,verbs=get;list;watch
+// +kubebuilder:rbac:groups=metal3.io,resources=baremetalhosts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hostpools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hosts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hosts/status,verbs=get;update;patch
 func (r *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Started Machine Reconciler")
@@ -109,49 +176,85 @@ func (r *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, fmt.Errorf("unable to get machine: %v", err)
 	}
-	modAddr, err := r.AddStatusAddressesFromAnnotations(m.Annotations)
-	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("unable to parse address annotations: %w", err)
-	}
 
-	if len(modAddr) == 0 && LegacyHostnameRegex.Match([]byte(m.GetName())) && len(m.Status.Addresses) == 0 && m.Spec.ProviderID == nil {
-		modAddr, err = r.AddStatusAddressesFromHostname(m.GetName())
-		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to process addresses from hostname: %w", err)
-		}
+	if !m.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, m)
+	}
+	if updated, err := r.ensureFinalizer(ctx, m); err != nil || updated {
+		return ctrl.Result{Requeue: updated}, err
+	}
+	if res, err := r.reconcilePowerAction(ctx, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	if res, err := r.reconcileReprovision(ctx, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	if res, err := r.reconcileHostClaim(ctx, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	if res, err := r.reconcileProvision(ctx, m); err != nil || !res.IsZero() {
+		return res, err
 	}
 
-	if len(modAddr) > 0 {
-		// Add addresses from machine.Status.Addresses that we dont create if they exist to prevent trashing
-		for _, eAddr := range m.Status.Addresses {
-			typeFound := false
-			for _, mAddr := range modAddr {
-				if eAddr.Type == mAddr.Type {
-					typeFound = true
-					break
-				}
-			}
-			if !typeFound {
-				modAddr = append(modAddr, *eAddr.DeepCopy())
-			}
+	if m.Spec.ProviderID == nil || *m.Spec.ProviderID == "" {
+		providerID, err := r.providerIDForMachine(m)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to determine providerID: %w", err)
 		}
-
-		if !(reflect.DeepEqual(modAddr, m.Status.Addresses)) {
-			logger.Info("Adding Addresses to Machine Status", "Status", "m.Status")
-			m.Status.Addresses = modAddr
-			logger.Info("New Status", "Status", m.Status)
-			if err = r.Client.Status().Update(ctx, m); err != nil {
+		if providerID != "" {
+			m.Spec.ProviderID = &providerID
+			logger.Info("Setting providerID", "ProviderID", providerID)
+			if err = r.Client.Update(ctx, m); err != nil {
 				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
 			}
 			return ctrl.Result{Requeue: true, RequeueAfter: requeueAfter}, nil
 		}
 	}
+
+	bmh, bmhErr := r.bareMetalHostFor(ctx, m)
+	if bmhErr != nil && !errors.Is(bmhErr, errBareMetalHostNotFound) {
+		return ctrl.Result{}, bmhErr
+	}
+	modAddr, addrSources, err := r.desiredAddresses(ctx, m, bmh)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !(reflect.DeepEqual(modAddr, m.Status.Addresses)) {
+		logger.Info("Adding Addresses to Machine Status", "Status", "m.Status")
+		m.Status.Addresses = modAddr
+		logger.Info("New Status", "Status", m.Status)
+		if err = r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+		return ctrl.Result{Requeue: true, RequeueAfter: requeueAfter}, nil
+	}
+
+	if res, err := r.reconcileNodeName(ctx, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	if res, err := r.reconcileMACAddress(ctx, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	identityRes, err := r.reconcileNodeIdentity(ctx, m)
+	if err != nil || identityRes.Requeue {
+		return identityRes, err
+	}
+
 	// If phase management key is set,  we will manage the phase
 	if _, ok := m.Annotations[getAnnotationKey(PhaseAnnotation)]; ok {
 		phase, err := r.setPhase(m, ctx)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("unable to parse phase status: %w", err)
 		}
+		if nodeReplacementFailed(m) {
+			phase = phaseFailed
+		}
+		if phase == phaseFailed && !reflect.DeepEqual(m.Status.Phase, &phase) {
+			// A failed control plane machine is remediated by deletion, hold the phase while that would break etcd quorum
+			if res, err := r.guardEtcdQuorum(ctx, m, "Phase change to Failed"); err != nil || !res.IsZero() {
+				return res, err
+			}
+		}
 		if !reflect.DeepEqual(m.Status.Phase, &phase) {
 			m.Status.Phase = &phase
 			logger.Info("New Phase", "Status", m.Status)
@@ -162,40 +265,80 @@ func (r *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		}
 	}
 
-	if value, ok := m.Annotations[getAnnotationKey(ProviderStateAnnotation)]; ok {
-		var ps *providerStatus
-		var err error
-		if ps, err = providerStatusFromRawExtension(m.Status.ProviderStatus); err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to parse provider status: %w", err)
-		}
-		if ps.ProvidedBy != nil && *ps.ProvidedBy != AnnotationBase {
-			return ctrl.Result{Requeue: false}, fmt.Errorf("refusing to change provider status: %v", ps)
+	if r.SetNodeProviderID && m.Spec.ProviderID != nil && *m.Spec.ProviderID != "" && m.Status.NodeRef != nil {
+		if err := r.setNodeProviderID(ctx, m); err != nil {
+			return ctrl.Result{}, err
 		}
-		newPs := &providerStatus{
-			InstanceState: &value,
-			ProvidedBy:    &myProviderName,
-		}
-		if !(reflect.DeepEqual(ps, newPs)) {
+	}
 
-			if m.Status.ProviderStatus, err = newPs.toRawExtension(); err != nil {
-				return ctrl.Result{}, fmt.Errorf("unable to create RawExtension: %w", err)
-			}
-			logger.Info("New providerStatus", "Status", m.Status)
-			if err = r.Client.Status().Update(ctx, m); err != nil {
-				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
-			}
-			return ctrl.Result{Requeue: true, RequeueAfter: requeueAfter}, nil
+	heartbeat, requeue, err := r.heartbeatState(ctx, m)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if res, err := r.updateProviderStatus(ctx, m, addrSources, heartbeat, bmh, bmhErr); err != nil || !res.IsZero() {
+		return res, err
+	}
+
+	// Update the instance state once the heartbeat Lease turns stale, the BMC is due a poll, or the BareMetalHost or NetBox is due a read
+	if hasBMC(m) {
+		if _, pollAfter, _ := r.bmcPowerState(ctx, m); requeue == 0 || pollAfter < requeue {
+			requeue = pollAfter
 		}
 	}
+	if hasBareMetalHost(m) && !r.BareMetalHosts && (requeue == 0 || bareMetalHostPollInterval < requeue) {
+		requeue = bareMetalHostPollInterval
+	}
+	if _, lookupAfter, _ := r.netboxAddresses(ctx, m); lookupAfter > 0 && (requeue == 0 || lookupAfter < requeue) {
+		requeue = lookupAfter
+	}
+	// Check an unresolved node replacement again
+	if identityRes.RequeueAfter > 0 && (requeue == 0 || identityRes.RequeueAfter < requeue) {
+		requeue = identityRes.RequeueAfter
+	}
+	// Retry a failed provisioner reconcile action
+	if c := conditions.Get(m, conditionProvisionerReconciled); c != nil && c.Status == corev1.ConditionFalse && (requeue == 0 || provisionRequeueAfter < requeue) {
+		requeue = provisionRequeueAfter
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
 
-	return ctrl.Result{}, nil
+// Build the clients that were not given from the manager
+func (r *MachineReconciler) setupClients(mgr ctrl.Manager) error {
+	if r.KubeClient == nil {
+		kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return fmt.Errorf("unable to build kube client: %w", err)
+		}
+		r.KubeClient = kubeClient
+	}
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("machine-node-linker")
+	}
+	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *MachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := r.setupClients(mgr); err != nil {
+		return err
+	}
+	r.bareMetalHostReader = mgr.GetCache()
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&machinev1.Machine{}).
-		Complete(r)
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.machinesForNode))
+	if r.HeartbeatLeases {
+		b = b.Watches(&coordinationv1.Lease{}, handler.EnqueueRequestsFromMapFunc(leaseToMachine))
+	}
+	if r.BareMetalHosts {
+		bmh := &unstructured.Unstructured{}
+		bmh.SetGroupVersionKind(bareMetalHostGVK)
+		b = b.Watches(bmh, handler.EnqueueRequestsFromMapFunc(r.machinesForBareMetalHost))
+	}
+	if r.AddressInventory != nil {
+		b = b.Watches(r.AddressInventory.Object(), handler.EnqueueRequestsFromMapFunc(r.machinesForAddressInventory),
+			builder.WithPredicates(r.addressInventoryPredicate()))
+	}
+	return b.Complete(r)
 }
 
 // Create a slice of NodeAddress objects based on a hostname matching ip-x-x-x-x
@@ -290,38 +433,177 @@ func (r *MachineReconciler) setPhase(m *machinev1.Machine, ctx context.Context)
 	return *currentPhase, nil
 }
 
-func getAnnotationKey(key string) string {
-	return fmt.Sprintf("%s/%s", AnnotationBase, key)
-}
+// Write providerStatus when this operator is configured to provide it, or when
+// an older providerStatus of ours needs to be migrated to the current version
+func (r *MachineReconciler) updateProviderStatus(ctx context.Context, m *machinev1.Machine, addrSources []string, heartbeat string, bmh *bareMetalHost, bmhErr error) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	newPs, err := r.desiredProviderStatus(ctx, m, addrSources, heartbeat, bmh, bmhErr)
+	if err != nil || newPs == nil {
+		return ctrl.Result{}, err
+	}
 
-func providerStatusFromRawExtension(raw *runtime.RawExtension) (*providerStatus, error) {
-	if raw == nil {
-		return &providerStatus{}, nil
+	if m.Status.ProviderStatus, err = newPs.toRawExtension(); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to create RawExtension: %w", err)
+	}
+	logger.Info("New providerStatus", "Status", m.Status)
+	if err = r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
 	}
+	return ctrl.Result{Requeue: true, RequeueAfter: requeueAfter}, nil
+}
 
-	ps := &providerStatus{}
-	strict, err := kjson.UnmarshalStrict(raw.Raw, ps)
+// Build status.addresses from the address sources, preserving addresses of types no source provides
+// bmh is the BareMetalHost from bareMetalHostFor, also returns the names of the sources used
+func (r *MachineReconciler) desiredAddresses(ctx context.Context, m *machinev1.Machine, bmh *bareMetalHost) ([]corev1.NodeAddress, []string, error) {
+	annotationAddr, err := r.AddStatusAddressesFromAnnotations(m.Annotations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse address annotations: %w", err)
+	}
+	inventoryAddr, err := r.addressInventoryAddresses(ctx, m)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create providerStatus from RawExtension %v", err)
+		return nil, nil, err
+	}
+	sources := r.annotationAndInventorySources(annotationAddr, inventoryAddr)
+
+	// The BareMetalHost, then NetBox, provide the address types the sources before them do not
+	if bmh != nil {
+		sources = append(sources, addressSource{addressSourceBareMetalHost, bmh.Addresses})
+	}
+	// NetBox failures are reported by the NetBoxDeviceFound condition
+	netboxAddr, _, _ := r.netboxAddresses(ctx, m)
+	sources = append(sources, addressSource{addressSourceNetBox, netboxAddr})
+
+	var addrSources []string
+	var modAddr []corev1.NodeAddress
+	for _, source := range sources {
+		var added bool
+		if modAddr, added = addMissingAddressTypes(modAddr, source.addresses); added {
+			addrSources = append(addrSources, source.name)
+		}
+	}
+
+	if len(modAddr) == 0 && LegacyHostnameRegex.Match([]byte(m.GetName())) && m.Spec.ProviderID == nil {
+		addrSources = append(addrSources, addressSourceHostname)
+		if len(m.Status.Addresses) == 0 {
+			modAddr, err = r.AddStatusAddressesFromHostname(m.GetName())
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to process addresses from hostname: %w", err)
+			}
+		}
+	}
+
+	if len(modAddr) == 0 {
+		return m.Status.Addresses, addrSources, nil
+	}
+
+	// Add addresses from machine.Status.Addresses that we dont create if they exist to prevent trashing
+	for _, eAddr := range m.Status.Addresses {
+		typeFound := false
+		for _, mAddr := range modAddr {
+			if eAddr.Type == mAddr.Type {
+				typeFound = true
+				break
+			}
+		}
+		if !typeFound {
+			modAddr = append(modAddr, *eAddr.DeepCopy())
+		}
 	}
-	if len(strict) > 0 {
-		return nil, fmt.Errorf("unable to create providerStatus from RawExtension %v", strict)
+	return modAddr, addrSources, nil
+}
+
+// Addresses provided by one source, recorded by name in providerStatus.addressSources
+type addressSource struct {
+	name      string
+	addresses []corev1.NodeAddress
+}
+
+// Append the addresses whose type is not in addresses yet
+func addMissingAddressTypes(addresses, extra []corev1.NodeAddress) ([]corev1.NodeAddress, bool) {
+	present := map[corev1.NodeAddressType]bool{}
+	for _, a := range addresses {
+		present[a.Type] = true
 	}
-	return ps, nil
+	added := false
+	for _, a := range extra {
+		if !present[a.Type] {
+			addresses = append(addresses, a)
+			added = true
+		}
+	}
+	return addresses, added
 }
 
-func (ps *providerStatus) toRawExtension() (*runtime.RawExtension, error) {
-	if ps == nil {
-		return &runtime.RawExtension{}, nil
+// Build the providerStatus this operator should write, heartbeat is the state from heartbeatState
+// and bmh and bmhErr the result of bareMetalHostFor
+// Returns nil when the current providerStatus should be left as it is
+func (r *MachineReconciler) desiredProviderStatus(ctx context.Context, m *machinev1.Machine, addrSources []string, heartbeat string, bmh *bareMetalHost, bmhErr error) (*providerStatus, error) {
+	logger := log.FromContext(ctx)
+	state, hasState := m.Annotations[getAnnotationKey(ProviderStateAnnotation)]
+	powerKnown := false
+	if bmh != nil && bmh.InstanceState != "" {
+		state, hasState, powerKnown = bmh.InstanceState, true, true
+	}
+	var bmcErr error
+	if hasBMC(m) {
+		var power string
+		if power, _, bmcErr = r.bmcPowerState(ctx, m); bmcErr == nil {
+			state, hasState, powerKnown = instanceStateFromPower(power), true, true
+		}
+	}
+	// A powered off machine is stopped whatever its heartbeat says
+	if heartbeat != "" && (!powerKnown || state == InstanceStateRunning) {
+		state, hasState = heartbeat, true
+	}
+	instanceID, hasID := m.Annotations[getAnnotationKey(InstanceIDAnnotation)]
+	macs := machineMACAddresses(m)
+
+	ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+	inventory := slices.Contains(addrSources, addressSourceInventory) || usedAddressInventory(m)
+	if !hasState && !hasID && len(macs) == 0 && !hasBMC(m) && !hasBareMetalHost(m) && !r.usesNetBox(m) && !inventory {
+		// Nothing to provide, only migrate status we previously wrote
+		if err != nil || !ps.needsMigration() {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse provider status: %w", err)
+	}
+	if ps.ProvidedBy != nil && !ps.isOurs() {
+		return nil, fmt.Errorf("refusing to change provider status: %v", ps)
+	}
+
+	newPs := ps.migrate()
+	if hasState {
+		newPs.InstanceState = &state
+	}
+	if hasID {
+		newPs.InstanceID = &instanceID
+	}
+	newPs.MACAddresses = macs
+	newPs.setAddressSources(addrSources)
+	if hasBMC(m) {
+		newPs.setBMCReachable(bmcErr)
+	}
+	if hasBareMetalHost(m) {
+		newPs.setBareMetalHostFound(bmhErr)
+	}
+	if r.usesNetBox(m) {
+		_, _, netboxErr := r.netboxAddresses(ctx, m)
+		newPs.setNetBoxDeviceFound(netboxErr)
 	}
 
-	var rawBytes []byte
-	var err error
-	if rawBytes, err = json.Marshal(ps); err != nil {
-		return nil, fmt.Errorf("error marshalling providerStatus: %v", err)
+	if ps.needsMigration() {
+		logger.Info("Migrating providerStatus", "From", ps.APIVersion, "To", providerStatusAPIVersion)
+	} else if ps.equivalent(newPs) {
+		return nil, nil
 	}
 
-	return &runtime.RawExtension{
-		Raw: rawBytes,
-	}, nil
+	now := metav1.Now()
+	newPs.LastUpdated = &now
+	return newPs, nil
+}
+
+func getAnnotationKey(key string) string {
+	return annotations.Key(key)
 }
@@ -7,11 +7,14 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gstruct"
 	machinev1 "github.com/openshift/api/machine/v1beta1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 )
 
 // +kubebuilder:docs-gen:collapse=Imports
@@ -264,6 +267,7 @@ var _ = Describe("Machine controller", func() {
 				ctx              context.Context
 				machineLookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
 				instanceState    = "teststate"
+				instanceID       = "test-instance"
 			)
 			BeforeEach(func() {
 				By("By creating a new machine")
@@ -278,6 +282,7 @@ var _ = Describe("Machine controller", func() {
 						Namespace: MachineNamespace,
 						Annotations: map[string]string{
 							getAnnotationKey(ProviderStateAnnotation): instanceState,
+							getAnnotationKey(InstanceIDAnnotation):    instanceID,
 						},
 					},
 					Spec: machinev1.MachineSpec{},
@@ -305,10 +310,16 @@ var _ = Describe("Machine controller", func() {
 				Expect(createdMachine).ShouldNot(Equal(&machinev1.Machine{}))
 
 				By("Creating ProviderStatus when machine annotation is created")
-				expectedProviderStatus := &providerStatus{
-					InstanceState: &instanceState,
-					ProvidedBy:    &myProviderName,
-				}
+				expectedProviderStatus := gstruct.PointTo(gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+					"TypeMeta": Equal(metav1.TypeMeta{
+						APIVersion: providerStatusAPIVersion,
+						Kind:       providerStatusKind,
+					}),
+					"InstanceID":    HaveValue(Equal(instanceID)),
+					"InstanceState": HaveValue(Equal(instanceState)),
+					"ProvidedBy":    HaveValue(Equal(myProviderName)),
+					"LastUpdated":   Not(BeNil()),
+				}))
 				Eventually(func() *providerStatus {
 					err := k8sClient.Get(ctx, machineLookupKey, createdMachine)
 					if err != nil {
@@ -319,14 +330,12 @@ var _ = Describe("Machine controller", func() {
 						return &providerStatus{}
 					}
 					return ps
-				}, timeout, interval).Should(Equal(expectedProviderStatus))
+				}, timeout, interval).Should(expectedProviderStatus)
 
 				By("Updating ProviderStatus when machine annotation is different")
 				var newstate = "newstate"
-				diffProviderStatus := &providerStatus{
-					InstanceState: &newstate,
-					ProvidedBy:    &myProviderName,
-				}
+				diffProviderStatus := newProviderStatus()
+				diffProviderStatus.InstanceState = &newstate
 				var err error
 				createdMachine.Status.ProviderStatus, err = diffProviderStatus.toRawExtension()
 				Expect(err).Should(BeNil())
@@ -342,10 +351,42 @@ var _ = Describe("Machine controller", func() {
 						return &providerStatus{}
 					}
 					return ps
-				}, timeout, interval).Should(Equal(expectedProviderStatus))
+				}, timeout, interval).Should(expectedProviderStatus)
 
 			})
 
+			DescribeTable("Should migrate ProviderStatus written in the legacy format",
+				func(annotated bool) {
+					if !annotated {
+						rawMachine.Annotations = nil
+					}
+					Expect(k8sClient.Create(ctx, rawMachine)).Should(Succeed())
+
+					createdMachine := &machinev1.Machine{}
+
+					Eventually(func() bool {
+						return k8sClient.Get(ctx, machineLookupKey, createdMachine) == nil
+					}, timeout, interval).Should(BeTrue())
+
+					legacyProviderStatus := []byte(fmt.Sprintf("{\"instanceState\":%q,\"providedBy\":%q}", instanceState, myProviderName))
+					Eventually(func() error {
+						k8sClient.Get(ctx, machineLookupKey, createdMachine)
+						createdMachine.Status.ProviderStatus = &runtime.RawExtension{Raw: legacyProviderStatus}
+						return k8sClient.Status().Update(ctx, createdMachine)
+					}, timeout, interval).Should(Succeed())
+
+					Eventually(func(g Gomega) {
+						g.Expect(k8sClient.Get(ctx, machineLookupKey, createdMachine)).Should(Succeed())
+						ps, err := providerStatusFromRawExtension(createdMachine.Status.ProviderStatus)
+						g.Expect(err).ShouldNot(HaveOccurred())
+						g.Expect(ps.APIVersion).Should(Equal(providerStatusAPIVersion))
+						g.Expect(ps.InstanceState).Should(HaveValue(Equal(instanceState)))
+					}, timeout, interval).Should(Succeed())
+				},
+				Entry("with provider annotations", true),
+				Entry("without annotations", false),
+			)
+
 			It("Should not modify ProviderStatus if it exists and was set by another process", func() {
 				Expect(k8sClient.Create(ctx, rawMachine)).Should(Succeed())
 
@@ -377,6 +418,62 @@ var _ = Describe("Machine controller", func() {
 			})
 		})
 	})
+	Context("Updating Machine Spec ProviderID", func() {
+		When("Machine Contains Proper Annotations", func() {
+			var (
+				rawMachine       *machinev1.Machine
+				ctx              context.Context
+				machineLookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+				providerID       = "baremetalhost:///openshift-machine-api/test-machine"
+			)
+			BeforeEach(func() {
+				By("By creating a new machine")
+				ctx = context.Background()
+				rawMachine = &machinev1.Machine{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: "machine.openshift.io/v1beta1",
+						Kind:       "Machine",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      MachineName,
+						Namespace: MachineNamespace,
+						Annotations: map[string]string{
+							getAnnotationKey(ProviderIDAnnotation): providerID,
+						},
+					},
+					Spec: machinev1.MachineSpec{},
+				}
+			})
+
+			AfterEach(func() {
+				Expect(k8sClient.Delete(ctx, rawMachine)).Should(Succeed())
+				Eventually(k8sClient.Get(ctx, machineLookupKey, &machinev1.Machine{})).ShouldNot(Succeed())
+			})
+
+			It("Should set the ProviderID once", func() {
+				Expect(k8sClient.Create(ctx, rawMachine)).Should(Succeed())
+
+				createdMachine := &machinev1.Machine{}
+
+				Eventually(func() *string {
+					k8sClient.Get(ctx, machineLookupKey, createdMachine)
+					return createdMachine.Spec.ProviderID
+				}, timeout, interval).Should(HaveValue(Equal(providerID)))
+
+				By("Not changing the ProviderID when the annotation changes")
+				Eventually(func() error {
+					k8sClient.Get(ctx, machineLookupKey, createdMachine)
+					createdMachine.Annotations[getAnnotationKey(ProviderIDAnnotation)] = "baremetalhost:///other"
+					return k8sClient.Update(ctx, createdMachine)
+				}, timeout, interval).Should(Succeed())
+
+				Consistently(func() *string {
+					k8sClient.Get(ctx, machineLookupKey, createdMachine)
+					return createdMachine.Spec.ProviderID
+				}, duration/2, interval).Should(HaveValue(Equal(providerID)))
+			})
+		})
+	})
 	Context("Manage Machine Status Phase", func() {
 		When("Machine Contains Proper Annotations", func() {
 			var (
@@ -477,4 +574,114 @@ var _ = Describe("Machine controller", func() {
 		})
 	})
 
+	Context("Watching Related Objects", func() {
+		var (
+			rawMachine       *machinev1.Machine
+			ctx              context.Context
+			machineLookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+		)
+		BeforeEach(func() {
+			ctx = context.Background()
+			rawMachine = &machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        MachineName,
+					Namespace:   MachineNamespace,
+					Annotations: map[string]string{},
+				},
+			}
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, rawMachine)).Should(Succeed())
+			Eventually(func() error {
+				return k8sClient.Get(ctx, machineLookupKey, &machinev1.Machine{})
+			}, timeout, interval).ShouldNot(Succeed())
+		})
+
+		It("Should reconcile the machine when its address inventory entry changes", func() {
+			rawMachine.Annotations[getAnnotationKey(HostnameAnnotation)] = MachineHostname
+			Expect(k8sClient.Create(ctx, rawMachine)).Should(Succeed())
+
+			inventory := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: testAddressInventory.Name, Namespace: testAddressInventory.Namespace},
+				Data:       map[string]string{MachineName: fmt.Sprintf("internalIP: %s\n", MachineIP)},
+			}
+			Expect(k8sClient.Create(ctx, inventory)).Should(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(context.Background(), inventory)).Should(Succeed())
+			})
+
+			createdMachine := &machinev1.Machine{}
+			Eventually(func() []corev1.NodeAddress {
+				k8sClient.Get(ctx, machineLookupKey, createdMachine)
+				return createdMachine.Status.Addresses
+			}, timeout, interval).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: MachineIP}))
+
+			By("Updating the entry")
+			inventory.Data[MachineName] = "internalIP: 5.6.7.8\n"
+			Expect(k8sClient.Update(ctx, inventory)).Should(Succeed())
+			Eventually(func() []corev1.NodeAddress {
+				k8sClient.Get(ctx, machineLookupKey, createdMachine)
+				return createdMachine.Status.Addresses
+			}, timeout, interval).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "5.6.7.8"}))
+		})
+
+		It("Should reconcile the machine when its heartbeat Lease is renewed", func() {
+			rawMachine.Annotations[getAnnotationKey(InternalIPAnnotation)] = MachineIP
+			rawMachine.Annotations[getAnnotationKey(ProviderStateAnnotation)] = "unknown"
+			Expect(k8sClient.Create(ctx, rawMachine)).Should(Succeed())
+
+			instanceState := func() string {
+				m := &machinev1.Machine{}
+				if err := k8sClient.Get(ctx, machineLookupKey, m); err != nil {
+					return ""
+				}
+				ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+				if err != nil || ps.InstanceState == nil {
+					return ""
+				}
+				return *ps.InstanceState
+			}
+			Eventually(instanceState, timeout, interval).Should(Equal("unknown"))
+
+			renewTime := metav1.NewMicroTime(time.Now())
+			lease := &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Name: MachineName, Namespace: MachineNamespace},
+				Spec: coordinationv1.LeaseSpec{
+					RenewTime:            &renewTime,
+					LeaseDurationSeconds: ptr.To[int32](40),
+				},
+			}
+			Expect(k8sClient.Create(ctx, lease)).Should(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(context.Background(), lease)).Should(Succeed())
+			})
+
+			Eventually(instanceState, timeout, interval).Should(Equal(InstanceStateBooting))
+		})
+
+		It("Should link the machine when the node named by the node-name annotation is created", func() {
+			const nodeName = "named-node"
+			rawMachine.Annotations[getAnnotationKey(NodeNameAnnotation)] = nodeName
+			rawMachine.Annotations[getAnnotationKey(InternalIPAnnotation)] = MachineIP
+			Expect(k8sClient.Create(ctx, rawMachine)).Should(Succeed())
+
+			createdMachine := &machinev1.Machine{}
+			Consistently(func() *corev1.ObjectReference {
+				k8sClient.Get(ctx, machineLookupKey, createdMachine)
+				return createdMachine.Status.NodeRef
+			}, interval*4, interval).Should(BeNil())
+
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+			Expect(k8sClient.Create(ctx, node)).Should(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(context.Background(), node)).Should(Succeed())
+			})
+
+			Eventually(func() *corev1.ObjectReference {
+				k8sClient.Get(ctx, machineLookupKey, createdMachine)
+				return createdMachine.Status.NodeRef
+			}, timeout, interval).Should(HaveField("Name", nodeName))
+		})
+	})
 })
@@ -0,0 +1,332 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+)
+
+const (
+	// Capacity of the machines of a MachineSet as comma separated resource=quantity pairs
+	// Ex. cpu=8,memory=32Gi,nvidia.com/gpu=1
+	CapacityAnnotation = "capacity"
+
+	// Annotations read by the cluster-autoscaler to scale a MachineSet from zero
+	cpuKey     = "machine.openshift.io/vCPU"
+	memoryKey  = "machine.openshift.io/memoryMb"
+	gpuKey     = "machine.openshift.io/GPU"
+	maxPodsKey = "machine.openshift.io/maxPods"
+
+	// Resource counted for the GPU annotation
+	gpuResource corev1.ResourceName = "nvidia.com/gpu"
+
+	capacitySourceAnnotation = "annotation"
+	capacitySourceHostPool   = "hostpool"
+	capacitySourceNodes      = "nodes"
+)
+
+// MachineSetReconciler keeps the cluster-autoscaler scale from zero annotations of MachineSets up to date
+type MachineSetReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Use and watch the HostPool inventory, requires the HostPool CRDs
+	HostPools bool
+}
+
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machinesets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch
+// +kubebuilder:rbac:groups=,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hostpools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=inventory.machine-node-linker.github.com,resources=hosts,verbs=get;list;watch
+func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	ms := &machinev1.MachineSet{}
+	if err := r.Client.Get(ctx, req.NamespacedName, ms); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get machineset: %v", err)
+	}
+	if !ms.DeletionTimestamp.IsZero() || !isLinkerManagedMachineSet(ms) {
+		return ctrl.Result{}, nil
+	}
+
+	capacity, source, err := r.machineSetCapacity(ctx, ms)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if capacity == nil {
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(ms.DeepCopy())
+	if !setScaleFromZeroAnnotations(ms, capacity) {
+		return ctrl.Result{}, nil
+	}
+	logger.Info("Setting scale from zero annotations", "Source", source, "Capacity", capacity)
+	if err := r.Client.Patch(ctx, ms, patch); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to patch machineset: %w", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// MachineSet carries a machine-node-linker.github.com/ annotation or creates machines that do
+// MachineSets of other providers are left to them
+func isLinkerManagedMachineSet(ms *machinev1.MachineSet) bool {
+	for _, annotations := range []map[string]string{ms.Annotations, ms.Spec.Template.Annotations} {
+		for key := range annotations {
+			if strings.HasPrefix(key, AnnotationBase+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Find the capacity of the machines of a MachineSet
+// The capacity annotation wins over the hosts of a matching HostPool, which win over the linked nodes
+// Returns nil when no source knows the capacity
+func (r *MachineSetReconciler) machineSetCapacity(ctx context.Context, ms *machinev1.MachineSet) (corev1.ResourceList, string, error) {
+	if value, ok := ms.Annotations[getAnnotationKey(CapacityAnnotation)]; ok {
+		capacity, err := parseCapacity(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to parse capacity annotation: %w", err)
+		}
+		return capacity, capacitySourceAnnotation, nil
+	}
+
+	if r.HostPools {
+		capacity, err := r.hostPoolCapacity(ctx, ms)
+		if err != nil || capacity != nil {
+			return capacity, capacitySourceHostPool, err
+		}
+	}
+
+	capacity, err := r.nodeCapacity(ctx, ms)
+	return capacity, capacitySourceNodes, err
+}
+
+// Smallest capacity of the hosts of the pool whose machine selector matches the MachineSet template
+func (r *MachineSetReconciler) hostPoolCapacity(ctx context.Context, ms *machinev1.MachineSet) (corev1.ResourceList, error) {
+	pools := &inventoryv1alpha1.HostPoolList{}
+	if err := r.Client.List(ctx, pools, client.InNamespace(ms.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list host pools: %w", err)
+	}
+
+	for _, pool := range pools.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&pool.Spec.MachineSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid machine selector in host pool %q: %w", pool.Name, err)
+		}
+		if selector.Empty() || !selector.Matches(labels.Set(ms.Spec.Template.Labels)) {
+			continue
+		}
+
+		hosts := &inventoryv1alpha1.HostList{}
+		if err := r.Client.List(ctx, hosts, client.InNamespace(ms.Namespace)); err != nil {
+			return nil, fmt.Errorf("unable to list hosts: %w", err)
+		}
+		var capacity corev1.ResourceList
+		for _, host := range hosts.Items {
+			if host.Spec.PoolName == pool.Name && !host.Spec.Disabled && len(host.Spec.Capacity) > 0 {
+				capacity = minCapacity(capacity, host.Spec.Capacity)
+			}
+		}
+		return capacity, nil
+	}
+	return nil, nil
+}
+
+// Smallest capacity of the nodes linked to the machines of the MachineSet
+func (r *MachineSetReconciler) nodeCapacity(ctx context.Context, ms *machinev1.MachineSet) (corev1.ResourceList, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&ms.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid machineset selector: %w", err)
+	}
+	machines := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, machines, client.InNamespace(ms.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("unable to list machines: %w", err)
+	}
+
+	var capacity corev1.ResourceList
+	for _, m := range machines.Items {
+		if m.Status.NodeRef == nil || !metav1.IsControlledBy(&m, ms) {
+			continue
+		}
+		n := &corev1.Node{}
+		if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: m.Status.NodeRef.Name}, n); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to get node: %v", err)
+		}
+		capacity = minCapacity(capacity, n.Status.Capacity)
+	}
+	return capacity, nil
+}
+
+// Parse comma separated resource=quantity pairs
+func parseCapacity(value string) (corev1.ResourceList, error) {
+	capacity := corev1.ResourceList{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, quantity, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected resource=quantity, got %q", pair)
+		}
+		q, err := resource.ParseQuantity(strings.TrimSpace(quantity))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for %q: %w", name, err)
+		}
+		capacity[corev1.ResourceName(strings.TrimSpace(name))] = q
+	}
+	return capacity, nil
+}
+
+// Resources present in both lists with the smaller quantity, a nil current list is replaced by next
+func minCapacity(current, next corev1.ResourceList) corev1.ResourceList {
+	if current == nil {
+		return next.DeepCopy()
+	}
+	for name, q := range current {
+		n, ok := next[name]
+		switch {
+		case !ok:
+			delete(current, name)
+		case n.Cmp(q) < 0:
+			current[name] = n.DeepCopy()
+		}
+	}
+	return current
+}
+
+// Set the scale from zero annotations from the capacity
+// The GPU annotation is only set when the capacity includes the GPU resource, and removed otherwise
+// Returns true when the annotations changed
+func setScaleFromZeroAnnotations(ms *machinev1.MachineSet, capacity corev1.ResourceList) bool {
+	values := map[string]string{}
+	if gpu, ok := capacity[gpuResource]; ok {
+		values[gpuKey] = strconv.FormatInt(gpu.Value(), 10)
+	}
+	if cpu, ok := capacity[corev1.ResourceCPU]; ok {
+		values[cpuKey] = strconv.FormatInt(cpu.Value(), 10)
+	}
+	if memory, ok := capacity[corev1.ResourceMemory]; ok {
+		values[memoryKey] = strconv.FormatInt(memory.Value()/(1024*1024), 10)
+	}
+	if pods, ok := capacity[corev1.ResourcePods]; ok {
+		values[maxPodsKey] = strconv.FormatInt(pods.Value(), 10)
+	}
+
+	changed := false
+	for key, value := range values {
+		if ms.Annotations == nil {
+			ms.Annotations = map[string]string{}
+		}
+		if ms.Annotations[key] != value {
+			ms.Annotations[key] = value
+			changed = true
+		}
+	}
+	if _, ok := values[gpuKey]; !ok {
+		if _, set := ms.Annotations[gpuKey]; set {
+			delete(ms.Annotations, gpuKey)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MachineSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&machinev1.MachineSet{}).
+		Watches(&machinev1.Machine{}, handler.EnqueueRequestsFromMapFunc(machineToMachineSet)).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.nodeToMachineSets))
+	if r.HostPools {
+		b = b.Watches(&inventoryv1alpha1.Host{}, handler.EnqueueRequestsFromMapFunc(r.hostToMachineSets))
+	}
+	return b.Complete(r)
+}
+
+// All MachineSets in the namespace of the host, the pool selectors are matched by Reconcile
+func (r *MachineSetReconciler) hostToMachineSets(ctx context.Context, o client.Object) []reconcile.Request {
+	machineSets := &machinev1.MachineSetList{}
+	if err := r.Client.List(ctx, machineSets, client.InNamespace(o.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list machinesets")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(machineSets.Items))
+	for _, ms := range machineSets.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: apitypes.NamespacedName{Name: ms.Name, Namespace: ms.Namespace}})
+	}
+	return requests
+}
+
+// The MachineSets owning the machines linked to the node, the node capacity is a capacity source
+func (r *MachineSetReconciler) nodeToMachineSets(ctx context.Context, o client.Object) []reconcile.Request {
+	machines := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, machines, client.MatchingFields{machineNodeRefIndex: o.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list machines")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range machines.Items {
+		requests = append(requests, machineToMachineSet(ctx, &machines.Items[i])...)
+	}
+	return requests
+}
+
+func machineToMachineSet(_ context.Context, o client.Object) []reconcile.Request {
+	owner := metav1.GetControllerOf(o)
+	if owner == nil || owner.Kind != "MachineSet" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: apitypes.NamespacedName{Name: owner.Name, Namespace: o.GetNamespace()}}}
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("MachineSet capacity", func() {
+
+	const (
+		MachineSetName   = "worker"
+		MachineNamespace = "openshift-machine-api"
+		MachineSetLabel  = "machine.openshift.io/cluster-api-machineset"
+	)
+
+	var (
+		ctx           context.Context
+		r             *MachineSetReconciler
+		rawMachineSet *machinev1.MachineSet
+		lookupKey     = types.NamespacedName{Name: MachineSetName, Namespace: MachineNamespace}
+	)
+
+	capacity := func(cpu, memory string) corev1.ResourceList {
+		return corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachineSet = &machinev1.MachineSet{
+			ObjectMeta: metav1.ObjectMeta{Name: MachineSetName, Namespace: MachineNamespace, UID: "machineset-uid"},
+			Spec: machinev1.MachineSetSpec{
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{MachineSetLabel: MachineSetName}},
+				Template: machinev1.MachineTemplateSpec{
+					ObjectMeta: machinev1.ObjectMeta{
+						Labels:      map[string]string{MachineSetLabel: MachineSetName},
+						Annotations: map[string]string{getAnnotationKey(ManagedAnnotation): ""},
+					},
+				},
+			},
+		}
+	})
+
+	newReconciler := func(objs ...client.Object) {
+		r = &MachineSetReconciler{
+			Client:    newFakeClient(objs...),
+			Scheme:    scheme.Scheme,
+			HostPools: true,
+		}
+	}
+
+	reconcileMachineSet := func() *machinev1.MachineSet {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: lookupKey})
+		Expect(err).ShouldNot(HaveOccurred())
+		ms := &machinev1.MachineSet{}
+		Expect(r.Client.Get(ctx, lookupKey, ms)).Should(Succeed())
+		return ms
+	}
+
+	It("Should use the capacity annotation", func() {
+		rawMachineSet.Annotations = map[string]string{getAnnotationKey(CapacityAnnotation): "cpu=8, memory=32Gi, nvidia.com/gpu=2"}
+		newReconciler(rawMachineSet)
+
+		ms := reconcileMachineSet()
+		Expect(ms.Annotations).Should(HaveKeyWithValue(cpuKey, "8"))
+		Expect(ms.Annotations).Should(HaveKeyWithValue(memoryKey, "32768"))
+		Expect(ms.Annotations).Should(HaveKeyWithValue(gpuKey, "2"))
+	})
+
+	It("Should use the smallest host of a matching pool", func() {
+		pool := &inventoryv1alpha1.HostPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: MachineNamespace},
+			Spec: inventoryv1alpha1.HostPoolSpec{
+				MachineSelector: metav1.LabelSelector{MatchLabels: map[string]string{MachineSetLabel: MachineSetName}},
+			},
+		}
+		host := func(name string, c corev1.ResourceList) *inventoryv1alpha1.Host {
+			return &inventoryv1alpha1.Host{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: MachineNamespace},
+				Spec:       inventoryv1alpha1.HostSpec{PoolName: "pool", Capacity: c},
+			}
+		}
+		newReconciler(rawMachineSet, pool, host("big", capacity("32", "128Gi")), host("small", capacity("16", "64Gi")))
+
+		ms := reconcileMachineSet()
+		Expect(ms.Annotations).Should(HaveKeyWithValue(cpuKey, "16"))
+		Expect(ms.Annotations).Should(HaveKeyWithValue(memoryKey, "65536"))
+		Expect(ms.Annotations).ShouldNot(HaveKey(gpuKey))
+	})
+
+	It("Should use the capacity of linked nodes", func() {
+		machine := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "worker-abcde",
+				Namespace:       MachineNamespace,
+				Labels:          map[string]string{MachineSetLabel: MachineSetName},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(rawMachineSet, machinev1.GroupVersion.WithKind("MachineSet"))},
+			},
+			Status: machinev1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: "node-0"}},
+		}
+		nodeCapacity := capacity("4", "16Gi")
+		nodeCapacity[corev1.ResourcePods] = resource.MustParse("250")
+		nodeCapacity[gpuResource] = resource.MustParse("1")
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+			Status:     corev1.NodeStatus{Capacity: nodeCapacity},
+		}
+		newReconciler(rawMachineSet, machine, node)
+
+		ms := reconcileMachineSet()
+		Expect(ms.Annotations).Should(HaveKeyWithValue(cpuKey, "4"))
+		Expect(ms.Annotations).Should(HaveKeyWithValue(memoryKey, "16384"))
+		Expect(ms.Annotations).Should(HaveKeyWithValue(maxPodsKey, "250"))
+		Expect(ms.Annotations).Should(HaveKeyWithValue(gpuKey, "1"))
+	})
+
+	It("Should map a node to the MachineSet of its machine", func() {
+		machine := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "worker-abcde",
+				Namespace:       MachineNamespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(rawMachineSet, machinev1.GroupVersion.WithKind("MachineSet"))},
+			},
+			Status: machinev1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: "node-0"}},
+		}
+		newReconciler(rawMachineSet, machine)
+
+		Expect(r.nodeToMachineSets(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}})).
+			Should(ConsistOf(ctrl.Request{NamespacedName: lookupKey}))
+		Expect(r.nodeToMachineSets(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})).Should(BeEmpty())
+	})
+
+	It("Should leave MachineSets of other providers alone", func() {
+		rawMachineSet.Spec.Template.Annotations = nil
+		machine := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "worker-abcde",
+				Namespace:       MachineNamespace,
+				Labels:          map[string]string{MachineSetLabel: MachineSetName},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(rawMachineSet, machinev1.GroupVersion.WithKind("MachineSet"))},
+			},
+			Status: machinev1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: "node-0"}},
+		}
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+			Status:     corev1.NodeStatus{Capacity: capacity("4", "16Gi")},
+		}
+		newReconciler(rawMachineSet, machine, node)
+
+		ms := reconcileMachineSet()
+		Expect(ms.Annotations).ShouldNot(HaveKey(cpuKey))
+	})
+
+	It("Should leave a MachineSet without a capacity source alone", func() {
+		newReconciler(rawMachineSet)
+
+		ms := reconcileMachineSet()
+		Expect(ms.Annotations).ShouldNot(HaveKey(cpuKey))
+	})
+})
@@ -0,0 +1,242 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/netbox"
+)
+
+const (
+	// ID of the NetBox device of the machine, the device is otherwise found by node-name annotation or machine name
+	NetBoxDeviceIDAnnotation = "netbox-device-id"
+
+	// Key of the NetBox API token in its Secret
+	NetBoxTokenKey = "token"
+
+	addressSourceNetBox = "netbox"
+
+	// Provider condition reporting whether the NetBox device of the machine was found
+	providerConditionNetBox    machinev1.ConditionType = "NetBoxDeviceFound"
+	reasonNetBoxDeviceNotFound                         = "NetBoxDeviceNotFound"
+	reasonNetBoxError                                  = "NetBoxError"
+
+	netboxTimeout         = 30 * time.Second
+	defaultNetBoxCacheTTL = 5 * time.Minute
+	netboxMinBackoff      = 10 * time.Second
+)
+
+// Last lookup of the NetBox device of a machine
+type netboxLookup struct {
+	addresses []corev1.NodeAddress
+	err       error
+	next      time.Time
+	// Consecutive failures other than a missing device
+	failures int
+}
+
+// NetBox lookups by machine UID, so every reconcile does not query NetBox
+// Entries not looked up again within a TTL of being due are dropped, so deleted machines do not stay cached
+type netboxCache struct {
+	mu      sync.Mutex
+	entries map[apitypes.UID]netboxLookup
+}
+
+func (c *netboxCache) get(uid apitypes.UID) (netboxLookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.entries[uid]
+	return l, ok
+}
+
+func (c *netboxCache) set(uid apitypes.UID, l netboxLookup, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[apitypes.UID]netboxLookup{}
+	}
+	for key, entry := range c.entries {
+		if time.Since(entry.next) > ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[uid] = l
+}
+
+func (c *netboxCache) forget(uid apitypes.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, uid)
+}
+
+// NetBox is configured and provides the addresses of the machine
+// Machines that are not linker-managed, or whose providerStatus is written by another provider, are only looked up
+// with the netbox-device-id annotation
+func (r *MachineReconciler) usesNetBox(m *machinev1.Machine) bool {
+	if r.NetBoxURL == "" {
+		return false
+	}
+	if m.Annotations[getAnnotationKey(NetBoxDeviceIDAnnotation)] != "" {
+		return true
+	}
+	if !isLinkerManaged(m) {
+		return false
+	}
+	if m.Status.ProviderStatus == nil {
+		return true
+	}
+	ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+	return err == nil && (ps.ProvidedBy == nil || ps.isOurs())
+}
+
+// Build a NetBox client with the token from its Secret
+func (r *MachineReconciler) netboxClient(ctx context.Context) (*netbox.Client, error) {
+	token := ""
+	if r.NetBoxTokenSecret.Name != "" {
+		secret, err := r.KubeClient.CoreV1().Secrets(r.NetBoxTokenSecret.Namespace).Get(ctx, r.NetBoxTokenSecret.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get NetBox token: %w", err)
+		}
+		token = string(secret.Data[NetBoxTokenKey])
+	}
+	return netbox.New(r.NetBoxURL, token, netboxTimeout)
+}
+
+// Addresses of the NetBox device of the machine, looked up at most once per NetBoxCacheTTL
+// Failures other than a missing device are retried with exponential backoff and keep the addresses of the last lookup
+// Also returns how long until the next lookup
+func (r *MachineReconciler) netboxAddresses(ctx context.Context, m *machinev1.Machine) ([]corev1.NodeAddress, time.Duration, error) {
+	if !r.usesNetBox(m) {
+		return nil, 0, nil
+	}
+	ttl := r.NetBoxCacheTTL
+	if ttl <= 0 {
+		ttl = defaultNetBoxCacheTTL
+	}
+	previous, ok := r.netboxCache.get(m.UID)
+	if ok {
+		if remaining := time.Until(previous.next); remaining > 0 {
+			return previous.addresses, remaining, previous.err
+		}
+	}
+
+	l := netboxLookup{}
+	l.addresses, l.err = r.lookupNetBoxDevice(ctx, m)
+	wait := ttl
+	if l.err != nil && !errors.Is(l.err, netbox.ErrNotFound) {
+		l.addresses = previous.addresses
+		l.failures = previous.failures + 1
+		if backoff := netboxMinBackoff << min(l.failures-1, 16); backoff < wait {
+			wait = backoff
+		}
+	}
+	l.next = time.Now().Add(wait)
+	r.netboxCache.set(m.UID, l, ttl)
+	return l.addresses, wait, l.err
+}
+
+// Query NetBox for the device of the machine and map its primary IPs and their DNS name to addresses
+func (r *MachineReconciler) lookupNetBoxDevice(ctx context.Context, m *machinev1.Machine) ([]corev1.NodeAddress, error) {
+	client, err := r.netboxClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var device *netbox.Device
+	if value := m.Annotations[getAnnotationKey(NetBoxDeviceIDAnnotation)]; value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s annotation %q", netbox.ErrNotFound, getAnnotationKey(NetBoxDeviceIDAnnotation), value)
+		}
+		device, err = client.Device(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		name := m.Annotations[getAnnotationKey(NodeNameAnnotation)]
+		if name == "" {
+			name = m.Name
+		}
+		if device, err = client.DeviceByName(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	var addresses []corev1.NodeAddress
+	dnsName := ""
+	for _, ip := range []*netbox.IPAddress{device.PrimaryIP4, device.PrimaryIP6} {
+		if ip == nil || ip.IP() == "" {
+			continue
+		}
+		addresses = append(addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip.IP()})
+		if dnsName != "" {
+			continue
+		}
+		full, err := client.IPAddress(ctx, ip.ID)
+		if err != nil && !errors.Is(err, netbox.ErrNotFound) {
+			return nil, err
+		}
+		if full != nil {
+			dnsName = full.DNSName
+		}
+	}
+	if dnsName != "" {
+		addresses = append(addresses, corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: dnsName})
+	}
+	return addresses, nil
+}
+
+// Record whether the NetBox device of the machine was found
+func (ps *providerStatus) setNetBoxDeviceFound(err error) {
+	if err == nil {
+		ps.setCondition(machinev1.Condition{
+			Type:   providerConditionNetBox,
+			Status: corev1.ConditionTrue,
+		})
+		return
+	}
+	reason := reasonNetBoxError
+	if errors.Is(err, netbox.ErrNotFound) {
+		reason = reasonNetBoxDeviceNotFound
+	}
+	ps.setCondition(machinev1.Condition{
+		Type:     providerConditionNetBox,
+		Status:   corev1.ConditionFalse,
+		Reason:   reason,
+		Severity: machinev1.ConditionSeverityWarning,
+		Message:  err.Error(),
+	})
+}
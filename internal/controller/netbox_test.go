@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("NetBox address source", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		SecretNamespace  = "machine-node-linker"
+		SecretName       = "netbox"
+		Token            = "0123456789abcdef"
+	)
+
+	var (
+		ctx        context.Context
+		r          *MachineReconciler
+		mock       *netboxMock
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mock = newNetBoxMock(Token)
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        MachineName,
+				Namespace:   MachineNamespace,
+				UID:         "machine-uid",
+				Annotations: map[string]string{getAnnotationKey(ManagedAnnotation): ""},
+			},
+		}
+		mock.addDevice(7, MachineName, "10.0.0.5/24", "fd00::5/64", "test-machine.example.com")
+	})
+
+	AfterEach(func() {
+		mock.server.Close()
+	})
+
+	newReconciler := func() {
+		r = newFakeMachineReconciler(rawMachine)
+		r.KubeClient = kubefake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: SecretName, Namespace: SecretNamespace},
+			Data:       map[string][]byte{NetBoxTokenKey: []byte(Token)},
+		})
+		r.NetBoxURL = mock.server.URL
+		r.NetBoxTokenSecret = types.NamespacedName{Namespace: SecretNamespace, Name: SecretName}
+	}
+
+	reconcile := func() ctrl.Result {
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	getMachine := func() (*machinev1.Machine, *providerStatus) {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		return m, ps
+	}
+
+	It("Should map the primary IPs and DNS name of the device to addresses", func() {
+		newReconciler()
+		res := reconcile()
+		Expect(res.RequeueAfter).Should(BeNumerically("~", defaultNetBoxCacheTTL, time.Second))
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "fd00::5"},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: "test-machine.example.com"},
+		))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceNetBox}))
+		Expect(ps.getCondition(providerConditionNetBox)).Should(HaveField("Status", corev1.ConditionTrue))
+		Expect(mock.requests("/api/dcim/devices/")).Should(Equal(1))
+	})
+
+	It("Should find the device by the netbox-device-id annotation", func() {
+		mock.addDevice(12, "rack1-u12", "10.0.1.12/24", "", "")
+		rawMachine.Annotations = map[string]string{getAnnotationKey(NetBoxDeviceIDAnnotation): "12"}
+		newReconciler()
+		reconcile()
+
+		m, _ := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.1.12"}))
+		Expect(mock.requests("/api/dcim/devices/12/")).Should(Equal(1))
+	})
+
+	It("Should prefer address annotations over NetBox", func() {
+		rawMachine.Annotations = map[string]string{getAnnotationKey(InternalIPAnnotation): "192.168.1.5"}
+		newReconciler()
+		reconcile()
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(ConsistOf(
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.1.5"},
+			corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: "test-machine.example.com"},
+		))
+		Expect(ps.AddressSources).Should(Equal([]string{addressSourceAnnotations, addressSourceNetBox}))
+	})
+
+	It("Should report a missing device", func() {
+		rawMachine.Annotations = map[string]string{getAnnotationKey(NodeNameAnnotation): "unknown"}
+		newReconciler()
+		reconcile()
+
+		m, ps := getMachine()
+		Expect(m.Status.Addresses).Should(BeEmpty())
+		Expect(ps.getCondition(providerConditionNetBox)).Should(And(
+			HaveField("Status", corev1.ConditionFalse),
+			HaveField("Reason", reasonNetBoxDeviceNotFound),
+		))
+	})
+
+	It("Should reuse lookups and back off while NetBox fails", func() {
+		newReconciler()
+		reconcile()
+		reconcile()
+		Expect(mock.requests("/api/dcim/devices/")).Should(Equal(1))
+
+		By("Keeping the addresses of the last lookup while NetBox fails")
+		mock.fail(true)
+		r.netboxCache.set(rawMachine.UID, netboxLookup{addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}}}, defaultNetBoxCacheTTL)
+		addresses, wait, err := r.netboxAddresses(ctx, rawMachine)
+		Expect(err).Should(HaveOccurred())
+		Expect(addresses).Should(ConsistOf(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}))
+		Expect(wait).Should(Equal(netboxMinBackoff))
+
+		l, _ := r.netboxCache.get(rawMachine.UID)
+		l.next = time.Now()
+		r.netboxCache.set(rawMachine.UID, l, defaultNetBoxCacheTTL)
+		_, wait, _ = r.netboxAddresses(ctx, rawMachine)
+		Expect(wait).Should(Equal(2 * netboxMinBackoff))
+
+		r.netboxCache.set(rawMachine.UID, netboxLookup{}, defaultNetBoxCacheTTL)
+		reconcile()
+		_, ps := getMachine()
+		Expect(ps.getCondition(providerConditionNetBox)).Should(HaveField("Reason", reasonNetBoxError))
+	})
+
+	It("Should skip machines that are not linker-managed", func() {
+		rawMachine.Annotations = nil
+		newReconciler()
+		Expect(r.usesNetBox(rawMachine)).Should(BeFalse())
+
+		rawMachine.Annotations = map[string]string{getAnnotationKey(NetBoxDeviceIDAnnotation): "7"}
+		Expect(r.usesNetBox(rawMachine)).Should(BeTrue())
+	})
+
+	It("Should drop cached lookups of machines no longer reconciled", func() {
+		newReconciler()
+		r.netboxCache.set("gone-uid", netboxLookup{next: time.Now().Add(-2 * defaultNetBoxCacheTTL)}, defaultNetBoxCacheTTL)
+		r.netboxCache.set(rawMachine.UID, netboxLookup{next: time.Now()}, defaultNetBoxCacheTTL)
+		_, ok := r.netboxCache.get("gone-uid")
+		Expect(ok).Should(BeFalse())
+		_, ok = r.netboxCache.get(rawMachine.UID)
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("Should skip machines of other providers", func() {
+		rawMachine.Status.ProviderStatus = &runtime.RawExtension{Raw: []byte(`{"kind":"AWSMachineProviderStatus","instanceId":"i-0123"}`)}
+		newReconciler()
+		Expect(r.usesNetBox(rawMachine)).Should(BeFalse())
+
+		rawMachine.Annotations = map[string]string{getAnnotationKey(NetBoxDeviceIDAnnotation): "7"}
+		Expect(r.usesNetBox(rawMachine)).Should(BeTrue())
+	})
+})
+
+// NetBox stand-in serving devices and IP addresses
+type netboxMock struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	devices   map[int]map[string]interface{}
+	ips       map[int]map[string]interface{}
+	counts    map[string]int
+	returnErr bool
+}
+
+func newNetBoxMock(token string) *netboxMock {
+	mock := &netboxMock{devices: map[int]map[string]interface{}{}, ips: map[int]map[string]interface{}{}, counts: map[string]int{}}
+	mock.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		mock.counts[req.URL.Path]++
+		if req.Header.Get("Authorization") != "Token "+token {
+			http.Error(w, `{"detail":"Invalid token"}`, http.StatusForbidden)
+			return
+		}
+		if mock.returnErr {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var body interface{}
+		switch {
+		case req.URL.Path == "/api/dcim/devices/":
+			results := []interface{}{}
+			for _, d := range mock.devices {
+				if d["name"] == req.URL.Query().Get("name") {
+					results = append(results, d)
+				}
+			}
+			body = map[string]interface{}{"count": len(results), "results": results}
+		case strings.HasPrefix(req.URL.Path, "/api/dcim/devices/"):
+			body = lookupByID(mock.devices, strings.TrimPrefix(req.URL.Path, "/api/dcim/devices/"))
+		case strings.HasPrefix(req.URL.Path, "/api/ipam/ip-addresses/"):
+			body = lookupByID(mock.ips, strings.TrimPrefix(req.URL.Path, "/api/ipam/ip-addresses/"))
+		}
+		if body == nil {
+			http.Error(w, `{"detail":"Not found."}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	return mock
+}
+
+// Object with the ID of a detail path such as 12/
+func lookupByID(objects map[int]map[string]interface{}, path string) interface{} {
+	id, err := strconv.Atoi(strings.TrimSuffix(path, "/"))
+	if err != nil || objects[id] == nil {
+		return nil
+	}
+	return objects[id]
+}
+
+// Add a device with primary IPs, the DNS name is set on both
+func (m *netboxMock) addDevice(id int, name, ip4, ip6, dnsName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	device := map[string]interface{}{"id": id, "name": name, "primary_ip4": nil, "primary_ip6": nil}
+	for i, address := range []string{ip4, ip6} {
+		if address == "" {
+			continue
+		}
+		ipID := id*10 + i
+		ip := map[string]interface{}{"id": ipID, "address": address, "dns_name": dnsName}
+		m.ips[ipID] = ip
+		// Devices only carry the brief representation of their primary IPs
+		brief := map[string]interface{}{"id": ipID, "address": address}
+		if i == 0 {
+			device["primary_ip4"] = brief
+		} else {
+			device["primary_ip6"] = brief
+		}
+	}
+	m.devices[id] = device
+}
+
+func (m *netboxMock) requests(path string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[path]
+}
+
+func (m *netboxMock) fail(fail bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.returnErr = fail
+}
@@ -0,0 +1,171 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Approves the replacement of the linked node, the value must be empty or the UID of the new node
+	ApproveNodeReplacementAnnotation = "approve-node-replacement"
+
+	// What to do when the linked node was replaced by a node with the same name
+	NodeReplacementAccept  = "accept"
+	NodeReplacementFail    = "fail"
+	NodeReplacementApprove = "approve"
+
+	// Machine condition reporting whether the linked node is the one first linked
+	conditionNodeIdentityVerified machinev1.ConditionType = "NodeIdentityVerified"
+	reasonNodeReplaced                                    = "NodeReplaced"
+	reasonNodeReplacementPending                          = "NodeReplacementPendingApproval"
+)
+
+// Record the UID of the linked node in providerStatus and apply the replacement policy when it changes
+// An unresolved replacement only sets the condition and returns a RequeueAfter, the rest of the reconcile goes on
+// Returns a result with Requeue set when the caller must stop
+func (r *MachineReconciler) reconcileNodeIdentity(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	if m.Status.NodeRef == nil || !hasLinkerAnnotations(m) {
+		return ctrl.Result{}, nil
+	}
+	ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+	if err != nil || (ps.ProvidedBy != nil && !ps.isOurs()) {
+		// Another process owns providerStatus
+		return ctrl.Result{}, nil
+	}
+
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: m.Status.NodeRef.Name}, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get node: %v", err)
+	}
+
+	if ps.NodeUID == nil {
+		return r.recordNodeUID(ctx, m, ps, n, "")
+	}
+	if *ps.NodeUID == n.UID {
+		return ctrl.Result{}, nil
+	}
+
+	logger := log.FromContext(ctx)
+	message := fmt.Sprintf("Node %q was replaced, UID changed from %s to %s", n.Name, *ps.NodeUID, n.UID)
+	policy := r.NodeReplacementPolicy
+	if r.VerifyReplacedNodeAddresses {
+		if mismatch := nodeAddressMismatch(m, n); len(mismatch) > 0 {
+			message = fmt.Sprintf("%s and does not report the machine addresses %s", message, strings.Join(mismatch, ", "))
+			if policy == NodeReplacementAccept || policy == "" {
+				policy = NodeReplacementApprove
+			}
+		}
+	}
+	logger.Info("Linked node replaced", "Node", n.Name, "Policy", policy, "Reason", message)
+
+	original := conditions.Get(m, conditionNodeIdentityVerified)
+	switch policy {
+	case NodeReplacementFail:
+		// The phase is moved to Failed with the other phase changes
+		conditions.MarkFalse(m, conditionNodeIdentityVerified, reasonNodeReplaced, machinev1.ConditionSeverityError, "%s", message)
+	case NodeReplacementApprove:
+		if value, ok := m.Annotations[getAnnotationKey(ApproveNodeReplacementAnnotation)]; ok && (value == "" || value == string(n.UID)) {
+			delete(m.Annotations, getAnnotationKey(ApproveNodeReplacementAnnotation))
+			if err := r.Client.Update(ctx, m); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+			}
+			return r.recordNodeUID(ctx, m, ps, n, message+", approved")
+		}
+		conditions.MarkFalse(m, conditionNodeIdentityVerified, reasonNodeReplacementPending, machinev1.ConditionSeverityWarning,
+			"%s, set the %s annotation to approve", message, getAnnotationKey(ApproveNodeReplacementAnnotation))
+	default:
+		return r.recordNodeUID(ctx, m, ps, n, message)
+	}
+
+	if updated := conditions.Get(m, conditionNodeIdentityVerified); original == nil || original.Reason != updated.Reason || original.Message != updated.Message {
+		r.recordEvent(m, corev1.EventTypeWarning, "NodeReplaced", "%s", message)
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+	}
+	// Check again until the replacement is resolved
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// The linked node was replaced and the fail policy applies
+func nodeReplacementFailed(m *machinev1.Machine) bool {
+	c := conditions.Get(m, conditionNodeIdentityVerified)
+	return c != nil && c.Status == corev1.ConditionFalse && c.Reason == reasonNodeReplaced
+}
+
+// Store the UID of the node in providerStatus, reporting an accepted replacement when message is set
+func (r *MachineReconciler) recordNodeUID(ctx context.Context, m *machinev1.Machine, ps *providerStatus, n *corev1.Node, message string) (ctrl.Result, error) {
+	newPs := ps.migrate()
+	newPs.NodeUID = &n.UID
+	now := metav1.Now()
+	newPs.LastUpdated = &now
+
+	var err error
+	if m.Status.ProviderStatus, err = newPs.toRawExtension(); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to create RawExtension: %w", err)
+	}
+	conditions.MarkTrue(m, conditionNodeIdentityVerified)
+	if message != "" {
+		r.recordEvent(m, corev1.EventTypeWarning, "NodeReplaced", "%s", message)
+	}
+	log.FromContext(ctx).Info("Recording node UID", "Node", n.Name, "UID", n.UID)
+	if err := r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// InternalIP addresses of the machine the node does not report
+func nodeAddressMismatch(m *machinev1.Machine, n *corev1.Node) []string {
+	nodeAddresses := map[string]bool{}
+	for _, addr := range n.Status.Addresses {
+		nodeAddresses[addr.Address] = true
+	}
+	var mismatch []string
+	for _, addr := range m.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP && !nodeAddresses[addr.Address] {
+			mismatch = append(mismatch, addr.Address)
+		}
+	}
+	sort.Strings(mismatch)
+	return mismatch
+}
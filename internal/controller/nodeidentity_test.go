@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Node identity", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		NodeName         = "test-node"
+		MachineIP        = "10.0.0.5"
+	)
+
+	var (
+		ctx       context.Context
+		r         *MachineReconciler
+		recorder  *record.FakeRecorder
+		lookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	nodeUID := func() *types.UID {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus)
+		Expect(err).ShouldNot(HaveOccurred())
+		return ps.NodeUID
+	}
+
+	// Replace the node with a new object of the same name
+	replaceNode := func(uid types.UID, ip string) {
+		Expect(r.Client.Delete(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: NodeName}})).Should(Succeed())
+		Expect(r.Client.Create(ctx, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeName, UID: uid},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}}},
+		})).Should(Succeed())
+	}
+
+	newReconciler := func(policy string) {
+		phase := phaseRunning
+		r = newFakeMachineReconciler(
+			&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      MachineName,
+					Namespace: MachineNamespace,
+					Annotations: map[string]string{
+						getAnnotationKey(InternalIPAnnotation): MachineIP,
+						getAnnotationKey(PhaseAnnotation):      "",
+					},
+				},
+				Status: machinev1.MachineStatus{
+					Phase:   &phase,
+					NodeRef: &corev1.ObjectReference{Kind: "Node", Name: NodeName},
+				},
+			},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: NodeName, UID: "first-uid"}},
+		)
+		r.NodeReplacementPolicy = policy
+		recorder = r.Recorder.(*record.FakeRecorder)
+	}
+
+	It("Should record the node UID and accept a replacement", func() {
+		newReconciler(NodeReplacementAccept)
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+		Expect(nodeUID()).Should(HaveValue(Equal(types.UID("first-uid"))))
+
+		replaceNode("second-uid", MachineIP)
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+		Expect(nodeUID()).Should(HaveValue(Equal(types.UID("second-uid"))))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("NodeReplaced")))
+	})
+
+	It("Should fail the machine when the policy is fail", func() {
+		newReconciler(NodeReplacementFail)
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		replaceNode("second-uid", MachineIP)
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(requeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Status.Phase).Should(HaveValue(Equal(phaseFailed)))
+		Expect(conditions.Get(m, conditionNodeIdentityVerified)).Should(HaveField("Reason", reasonNodeReplaced))
+		Expect(nodeUID()).Should(HaveValue(Equal(types.UID("first-uid"))))
+	})
+
+	It("Should wait for approval when the new node reports other addresses", func() {
+		newReconciler(NodeReplacementAccept)
+		r.VerifyReplacedNodeAddresses = true
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		replaceNode("second-uid", "10.0.0.99")
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		c := conditions.Get(m, conditionNodeIdentityVerified)
+		Expect(c.Reason).Should(Equal(reasonNodeReplacementPending))
+		Expect(c.Message).Should(ContainSubstring(MachineIP))
+
+		By("Approving the replacement")
+		m.Annotations[getAnnotationKey(ApproveNodeReplacementAnnotation)] = "second-uid"
+		Expect(r.Client.Update(ctx, m)).Should(Succeed())
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(ApproveNodeReplacementAnnotation)))
+		Expect(conditions.Get(m, conditionNodeIdentityVerified)).Should(HaveField("Status", corev1.ConditionTrue))
+		Expect(nodeUID()).Should(HaveValue(Equal(types.UID("second-uid"))))
+	})
+})
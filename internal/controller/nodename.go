@@ -0,0 +1,195 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/annotations"
+)
+
+const (
+	// Name of the node the machine is linked to, bypasses address matching
+	NodeNameAnnotation = annotations.NodeName
+
+	reasonNodeNameConflict = "NodeNameConflict"
+)
+
+// Link the machine to the node named by the node-name annotation once it exists
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcileNodeName(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	name := m.Annotations[getAnnotationKey(NodeNameAnnotation)]
+	if name == "" || (m.Status.NodeRef != nil && m.Status.NodeRef.Name == name) {
+		return ctrl.Result{}, nil
+	}
+
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: name}, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Linked once the node registers, see machinesForNode
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get node: %v", err)
+	}
+	if !n.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	return r.linkNode(ctx, m, n)
+}
+
+// Set the node as nodeRef of the machine unless another machine claims it
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) linkNode(ctx context.Context, m *machinev1.Machine, n *corev1.Node) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	owner, err := r.nodeClaimedBy(ctx, m, n)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if owner != nil {
+		logger.Info("Node is linked to another machine", "Node", n.Name, "Machine", client.ObjectKeyFromObject(owner))
+		r.recordEvent(m, corev1.EventTypeWarning, reasonNodeNameConflict, "Node %s is linked to machine %s/%s", n.Name, owner.Namespace, owner.Name)
+		r.recordEvent(owner, corev1.EventTypeWarning, reasonNodeNameConflict, "Machine %s/%s also names node %s", m.Namespace, m.Name, n.Name)
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+
+	logger.Info("Linking node", "Node", n.Name)
+	m.Status.NodeRef = &corev1.ObjectReference{
+		Kind: "Node",
+		Name: n.Name,
+		UID:  n.UID,
+	}
+	if err := r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+
+	// Record the link on the node the same way nodelink does
+	ref := fmt.Sprintf("%s/%s", m.Namespace, m.Name)
+	if n.Annotations[nodeMachineAnnotation] != ref {
+		patch := client.MergeFrom(n.DeepCopy())
+		if n.Annotations == nil {
+			n.Annotations = map[string]string{}
+		}
+		n.Annotations[nodeMachineAnnotation] = ref
+		if err := r.Client.Patch(ctx, n, patch); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to patch node: %w", err)
+		}
+	}
+	return ctrl.Result{Requeue: true, RequeueAfter: requeueAfter}, nil
+}
+
+// Returns another machine the node is linked to, nil when the machine may claim the node
+// The node belongs to the machine in its machine.openshift.io/machine annotation or linked to it in status.nodeRef.
+// When several unlinked machines name the node the oldest one gets it.
+func (r *MachineReconciler) nodeClaimedBy(ctx context.Context, m *machinev1.Machine, n *corev1.Node) (*machinev1.Machine, error) {
+	if ref := n.Annotations[nodeMachineAnnotation]; ref != "" && ref != fmt.Sprintf("%s/%s", m.Namespace, m.Name) {
+		namespace, name, _ := strings.Cut(ref, "/")
+		owner := &machinev1.Machine{}
+		err := r.Client.Get(ctx, apitypes.NamespacedName{Namespace: namespace, Name: name}, owner)
+		switch {
+		case err == nil:
+			return owner, nil
+		case !apierrors.IsNotFound(err):
+			return nil, fmt.Errorf("unable to get machine: %v", err)
+		}
+		// The annotation of a deleted machine is overwritten
+	}
+
+	linked := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, linked, client.MatchingFields{machineNodeRefIndex: n.Name}); err != nil {
+		return nil, fmt.Errorf("unable to list machines: %w", err)
+	}
+	for i := range linked.Items {
+		if !isSameMachine(&linked.Items[i], m) {
+			return &linked.Items[i], nil
+		}
+	}
+
+	named := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, named, client.MatchingFields{machineNodeNameIndex: n.Name}); err != nil {
+		return nil, fmt.Errorf("unable to list machines: %w", err)
+	}
+	for i := range named.Items {
+		other := &named.Items[i]
+		if isSameMachine(other, m) || (other.Status.NodeRef != nil && other.Status.NodeRef.Name != n.Name) {
+			continue
+		}
+		if olderMachine(other, m) {
+			return other, nil
+		}
+	}
+	return nil, nil
+}
+
+func isSameMachine(a, b *machinev1.Machine) bool {
+	return a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+// Machine a was created before machine b, ties are broken by namespace and name
+func olderMachine(a, b *machinev1.Machine) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return fmt.Sprintf("%s/%s", a.Namespace, a.Name) < fmt.Sprintf("%s/%s", b.Namespace, b.Name)
+}
+
+// Enqueue the machines naming the node in their node-name annotation, or waiting for a node with its MAC addresses
+func (r *MachineReconciler) machinesForNode(ctx context.Context, o client.Object) []reconcile.Request {
+	lists := []client.MatchingFields{{machineNodeNameIndex: o.GetName()}}
+	if n, ok := o.(*corev1.Node); ok {
+		for _, mac := range nodeMACAddresses(n) {
+			lists = append(lists, client.MatchingFields{machineMACAddressIndex: mac})
+		}
+	}
+	seen := map[apitypes.NamespacedName]bool{}
+	var requests []reconcile.Request
+	for _, fields := range lists {
+		machines := &machinev1.MachineList{}
+		if err := r.Client.List(ctx, machines, fields); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list machines")
+			return nil
+		}
+		for _, m := range machines.Items {
+			key := apitypes.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+			if !seen[key] {
+				seen[key] = true
+				requests = append(requests, reconcile.Request{NamespacedName: key})
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Node name annotation", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		NodeName         = "test-node"
+	)
+
+	var (
+		ctx       context.Context
+		r         *MachineReconciler
+		recorder  *record.FakeRecorder
+		objects   []client.Object
+		lookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		objects = []client.Object{
+			&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              MachineName,
+					Namespace:         MachineNamespace,
+					CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)),
+					Annotations: map[string]string{
+						getAnnotationKey(NodeNameAnnotation):   NodeName,
+						getAnnotationKey(InternalIPAnnotation): "10.0.0.5",
+					},
+				},
+			},
+		}
+	})
+
+	reconcile := func() {
+		r = newFakeMachineReconciler(objects...)
+		recorder = r.Recorder.(*record.FakeRecorder)
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+	}
+
+	machineNodeRef := func() *corev1.ObjectReference {
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		return m.Status.NodeRef
+	}
+
+	It("Should wait for the named node to exist", func() {
+		reconcile()
+		Expect(machineNodeRef()).Should(BeNil())
+		Expect(r.machinesForNode(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: NodeName}})).Should(HaveLen(1))
+	})
+
+	It("Should link the named node even when its addresses do not match", func() {
+		objects = append(objects, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeName, UID: "node-uid"},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.0.5"}}},
+		})
+		reconcile()
+		Expect(machineNodeRef()).Should(HaveValue(Equal(corev1.ObjectReference{Kind: "Node", Name: NodeName, UID: "node-uid"})))
+
+		n := &corev1.Node{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Name: NodeName}, n)).Should(Succeed())
+		Expect(n.Annotations).Should(HaveKeyWithValue(nodeMachineAnnotation, MachineNamespace+"/"+MachineName))
+	})
+
+	It("Should not link a node claimed by another machine", func() {
+		objects = append(objects,
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: NodeName}},
+			&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-machine", Namespace: MachineNamespace},
+				Status:     machinev1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: NodeName}},
+			},
+		)
+		reconcile()
+		Expect(machineNodeRef()).Should(BeNil())
+		Expect(recorder.Events).Should(Receive(ContainSubstring(reasonNodeNameConflict)))
+	})
+
+	It("Should give a node named by two machines to the oldest one", func() {
+		otherKey := types.NamespacedName{Name: "other-machine", Namespace: MachineNamespace}
+		objects = append(objects,
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: NodeName}},
+			&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              otherKey.Name,
+					Namespace:         otherKey.Namespace,
+					CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+					Annotations:       map[string]string{getAnnotationKey(NodeNameAnnotation): NodeName},
+				},
+			},
+		)
+		reconcile()
+		Expect(machineNodeRef()).Should(BeNil())
+		Expect(recorder.Events).Should(Receive(ContainSubstring("is linked to machine " + otherKey.String())))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("also names node " + NodeName)))
+
+		Expect(reconcileUntilSettled(ctx, r, otherKey)).Error().ShouldNot(HaveOccurred())
+		other := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, otherKey, other)).Should(Succeed())
+		Expect(other.Status.NodeRef).Should(HaveField("Name", NodeName))
+	})
+
+	It("Should not link a node annotated with another machine", func() {
+		objects = append(objects,
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        NodeName,
+				Annotations: map[string]string{nodeMachineAnnotation: MachineNamespace + "/other-machine"},
+			}},
+			&machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "other-machine", Namespace: MachineNamespace}},
+		)
+		reconcile()
+		Expect(machineNodeRef()).Should(BeNil())
+		Expect(recorder.Events).Should(Receive(ContainSubstring(reasonNodeNameConflict)))
+	})
+
+	It("Should link a node annotated with a deleted machine", func() {
+		objects = append(objects, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        NodeName,
+			Annotations: map[string]string{nodeMachineAnnotation: MachineNamespace + "/deleted-machine"},
+		}})
+		reconcile()
+		Expect(machineNodeRef()).Should(HaveField("Name", NodeName))
+	})
+})
@@ -0,0 +1,141 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Values available to the providerID template
+type providerIDTemplateData struct {
+	Name        string
+	Namespace   string
+	InstanceID  string
+	Hostname    string
+	Annotations map[string]string
+}
+
+// Parse a providerID template such as baremetalhost:///{{ .Namespace }}/{{ .Name }}
+func ParseProviderIDTemplate(text string) (*template.Template, error) {
+	t, err := template.New("providerID").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse providerID template: %w", err)
+	}
+	return t, nil
+}
+
+// Determine the providerID for a machine from the provider-id annotation, falling back to the template
+// Returns an empty string when no providerID should be set
+func (r *MachineReconciler) providerIDForMachine(m *machinev1.Machine) (string, error) {
+	if value, ok := m.Annotations[getAnnotationKey(ProviderIDAnnotation)]; ok {
+		return value, nil
+	}
+	if r.ProviderIDTemplate == nil || !hasLinkerAnnotations(m) {
+		return "", nil
+	}
+	return ExecuteProviderIDTemplate(r.ProviderIDTemplate, m)
+}
+
+// Build the providerID of a machine from a template parsed by ParseProviderIDTemplate
+func ExecuteProviderIDTemplate(t *template.Template, m *machinev1.Machine) (string, error) {
+	data := providerIDTemplateData{
+		Name:        m.GetName(),
+		Namespace:   m.GetNamespace(),
+		InstanceID:  m.Annotations[getAnnotationKey(InstanceIDAnnotation)],
+		Hostname:    m.Annotations[getAnnotationKey(HostnameAnnotation)],
+		Annotations: m.Annotations,
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("unable to execute providerID template: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// Set spec.providerID on the linked node if it does not already have one
+// Nodes only allow providerID to be set once, so an existing value is never changed
+func (r *MachineReconciler) setNodeProviderID(ctx context.Context, m *machinev1.Machine) error {
+	logger := log.FromContext(ctx)
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: m.Status.NodeRef.Name}, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get node: %v", err)
+	}
+	if n.Spec.ProviderID == *m.Spec.ProviderID {
+		return nil
+	}
+	if n.Spec.ProviderID != "" {
+		logger.Info("Node providerID does not match machine", "Node", n.Name, "NodeProviderID", n.Spec.ProviderID, "MachineProviderID", *m.Spec.ProviderID)
+		return nil
+	}
+
+	patch := client.MergeFrom(n.DeepCopy())
+	n.Spec.ProviderID = *m.Spec.ProviderID
+	logger.Info("Setting Node providerID", "Node", n.Name, "ProviderID", n.Spec.ProviderID)
+	if err := r.Client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("unable to patch node: %w", err)
+	}
+	return nil
+}
+
+// Machine carries at least one machine-node-linker.github.com/ annotation
+func hasLinkerAnnotations(m *machinev1.Machine) bool {
+	for key := range m.Annotations {
+		if strings.HasPrefix(key, AnnotationBase+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Machine is managed by this operator, through its annotations or an instance it provisioned
+// The managed annotation marks machines that get all their values from other sources
+func isLinkerManaged(m *machinev1.Machine) bool {
+	return hasLinkerAnnotations(m) || conditions.Get(m, conditionInstanceProvisioned) != nil
+}
+
+// Machine has an annotation that provides addresses
+func hasAddressAnnotations(m *machinev1.Machine) bool {
+	for _, key := range []string{InternalIPAnnotation, HostnameAnnotation, InternalDNSAnnotation} {
+		if _, ok := m.Annotations[getAnnotationKey(key)]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kjson "sigs.k8s.io/json"
+)
+
+const (
+	// Versioning for the providerStatus we write, mirroring the kind/apiVersion
+	// pair that the cloud providers put in their own provider status objects
+	providerStatusAPIVersion = AnnotationBase + "/v1alpha1"
+	providerStatusKind       = "LinkerProviderStatus"
+
+	// Names recorded in providerStatus.addressSources
+	addressSourceAnnotations = "annotations"
+	addressSourceHostname    = "hostname"
+
+	// Provider condition reporting whether any source gave the machine addresses
+	providerConditionAddresses machinev1.ConditionType = "AddressesProvided"
+	reasonNoAddressSource                              = "NoAddressSource"
+)
+
+// Object for serializing providerstatus object in machine status
+// API defines it as a RawExtension
+type providerStatus struct {
+	metav1.TypeMeta `json:",inline"`
+
+	InstanceID     *string               `json:"instanceId,omitempty"`
+	InstanceState  *string               `json:"instanceState,omitempty"`
+	ProvidedBy     *string               `json:"providedBy,omitempty"`
+	NodeUID        *types.UID            `json:"nodeUID,omitempty"`
+	MACAddresses   []string              `json:"macAddresses,omitempty"`
+	LastUpdated    *metav1.Time          `json:"lastUpdated,omitempty"`
+	AddressSources []string              `json:"addressSources,omitempty"`
+	Conditions     []machinev1.Condition `json:"conditions,omitempty"`
+}
+
+// Unversioned providerStatus written by earlier releases of this operator
+// Only used to decode and migrate existing machines
+type legacyProviderStatus struct {
+	InstanceState *string `json:"instanceState,omitempty"`
+	ProvidedBy    *string `json:"providedBy,omitempty"`
+}
+
+// Create an empty providerStatus of the current version owned by this operator
+func newProviderStatus() *providerStatus {
+	return &providerStatus{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: providerStatusAPIVersion,
+			Kind:       providerStatusKind,
+		},
+		ProvidedBy: &myProviderName,
+	}
+}
+
+func providerStatusFromRawExtension(raw *runtime.RawExtension) (*providerStatus, error) {
+	if raw == nil || len(raw.Raw) == 0 {
+		return &providerStatus{}, nil
+	}
+
+	tm := &metav1.TypeMeta{}
+	if err := json.Unmarshal(raw.Raw, tm); err != nil {
+		return nil, fmt.Errorf("unable to create providerStatus from RawExtension %v", err)
+	}
+
+	switch tm.APIVersion {
+	case "":
+		legacy := &legacyProviderStatus{}
+		if err := unmarshalStrict(raw.Raw, legacy); err != nil {
+			return nil, err
+		}
+		return &providerStatus{
+			InstanceState: legacy.InstanceState,
+			ProvidedBy:    legacy.ProvidedBy,
+		}, nil
+	case providerStatusAPIVersion:
+		if tm.Kind != providerStatusKind {
+			return nil, fmt.Errorf("unable to create providerStatus from RawExtension: unsupported kind %q", tm.Kind)
+		}
+		ps := &providerStatus{}
+		if err := unmarshalStrict(raw.Raw, ps); err != nil {
+			return nil, err
+		}
+		return ps, nil
+	default:
+		return nil, fmt.Errorf("unable to create providerStatus from RawExtension: unsupported apiVersion %q", tm.APIVersion)
+	}
+}
+
+func unmarshalStrict(data []byte, v interface{}) error {
+	strict, err := kjson.UnmarshalStrict(data, v)
+	if err != nil {
+		return fmt.Errorf("unable to create providerStatus from RawExtension %v", err)
+	}
+	if len(strict) > 0 {
+		return fmt.Errorf("unable to create providerStatus from RawExtension %v", strict)
+	}
+	return nil
+}
+
+func (ps *providerStatus) toRawExtension() (*runtime.RawExtension, error) {
+	if ps == nil {
+		return &runtime.RawExtension{}, nil
+	}
+
+	var rawBytes []byte
+	var err error
+	if rawBytes, err = json.Marshal(ps); err != nil {
+		return nil, fmt.Errorf("error marshalling providerStatus: %v", err)
+	}
+
+	return &runtime.RawExtension{
+		Raw: rawBytes,
+	}, nil
+}
+
+// providerStatus is owned by this operator
+func (ps *providerStatus) isOurs() bool {
+	return ps.ProvidedBy != nil && *ps.ProvidedBy == AnnotationBase
+}
+
+// providerStatus was decoded from an older schema and should be rewritten
+func (ps *providerStatus) needsMigration() bool {
+	return ps.isOurs() && (ps.APIVersion != providerStatusAPIVersion || ps.Kind != providerStatusKind)
+}
+
+// Copy of providerStatus in the current version, ready to be modified
+func (ps *providerStatus) migrate() *providerStatus {
+	out := newProviderStatus()
+	out.InstanceID = ps.InstanceID
+	out.InstanceState = ps.InstanceState
+	out.NodeUID = ps.NodeUID
+	out.MACAddresses = append([]string(nil), ps.MACAddresses...)
+	out.LastUpdated = ps.LastUpdated
+	out.AddressSources = append([]string(nil), ps.AddressSources...)
+	out.Conditions = append([]machinev1.Condition(nil), ps.Conditions...)
+	return out
+}
+
+// Compare two providerStatus objects ignoring LastUpdated
+func (ps *providerStatus) equivalent(other *providerStatus) bool {
+	a, b := *ps, *other
+	a.LastUpdated, b.LastUpdated = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// Get a provider condition by type
+func (ps *providerStatus) getCondition(t machinev1.ConditionType) *machinev1.Condition {
+	for i := range ps.Conditions {
+		if ps.Conditions[i].Type == t {
+			return &ps.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// Set a provider condition, only moving LastTransitionTime when the state changes
+func (ps *providerStatus) setCondition(c machinev1.Condition) {
+	if existing := ps.getCondition(c.Type); existing != nil {
+		if existing.Status == c.Status {
+			c.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			c.LastTransitionTime = metav1.NewTime(time.Now().UTC().Truncate(time.Second))
+		}
+		*existing = c
+		return
+	}
+	c.LastTransitionTime = metav1.NewTime(time.Now().UTC().Truncate(time.Second))
+	ps.Conditions = append(ps.Conditions, c)
+}
+
+// Record the address sources used for the machine and the matching condition
+func (ps *providerStatus) setAddressSources(sources []string) {
+	ps.AddressSources = append([]string(nil), sources...)
+	if len(sources) > 0 {
+		ps.setCondition(machinev1.Condition{
+			Type:   providerConditionAddresses,
+			Status: corev1.ConditionTrue,
+		})
+		return
+	}
+	ps.setCondition(machinev1.Condition{
+		Type:     providerConditionAddresses,
+		Status:   corev1.ConditionFalse,
+		Reason:   reasonNoAddressSource,
+		Severity: machinev1.ConditionSeverityWarning,
+		Message:  "no address source matched this machine",
+	})
+}
+
+// Record whether the last poll of the BMC succeeded
+func (ps *providerStatus) setBMCReachable(err error) {
+	if err == nil {
+		ps.setCondition(machinev1.Condition{
+			Type:   providerConditionBMC,
+			Status: corev1.ConditionTrue,
+		})
+		return
+	}
+	ps.setCondition(machinev1.Condition{
+		Type:     providerConditionBMC,
+		Status:   corev1.ConditionFalse,
+		Reason:   reasonBMCError,
+		Severity: machinev1.ConditionSeverityWarning,
+		Message:  err.Error(),
+	})
+}
@@ -0,0 +1,241 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+const (
+	// Runs the reprovision hook when set on the machine, removed once the hook succeeds
+	ReprovisionAnnotation = "reprovision"
+
+	// Machine conditions reporting the create/reprovision and delete hooks
+	conditionInstanceProvisioned   machinev1.ConditionType = "InstanceProvisioned"
+	conditionInstanceDeprovisioned machinev1.ConditionType = "InstanceDeprovisioned"
+	// Machine condition reporting the last reconcile action of the provisioner
+	conditionProvisionerReconciled machinev1.ConditionType = "ProvisionerReconciled"
+	reasonProvisionInProgress                              = "ProvisionInProgress"
+	reasonProvisionFailed                                  = "ProvisionFailed"
+
+	provisionRequeueAfter = 10 * time.Second
+)
+
+// Run the create or reprovision hook for a machine that needs one
+// Only machines managed by this operator are provisioned, so new machines need a linker annotation such as the managed annotation
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcileProvision(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	if r.Provisioner == nil || !isLinkerManaged(m) {
+		return ctrl.Result{}, nil
+	}
+
+	action := provision.ActionCreate
+	if _, ok := m.Annotations[getAnnotationKey(ReprovisionAnnotation)]; ok {
+		action = provision.ActionReprovision
+	} else if isConditionTrue(m, conditionInstanceProvisioned) || m.Status.NodeRef != nil || (m.Spec.ProviderID != nil && *m.Spec.ProviderID != "") {
+		return r.reconcileProvisionerState(ctx, m)
+	}
+
+	res, err := r.runProvisioner(ctx, m, action, conditionInstanceProvisioned)
+	if err != nil {
+		if uErr := r.Client.Status().Update(ctx, m); uErr != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", uErr)
+		}
+		if errors.Is(err, provision.ErrInProgress) {
+			return ctrl.Result{RequeueAfter: provisionRequeueAfter}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	changed := applyProvisionResult(m, res)
+	if action == provision.ActionReprovision {
+		delete(m.Annotations, getAnnotationKey(ReprovisionAnnotation))
+		changed = true
+	}
+	if changed {
+		if err := r.Client.Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+	}
+
+	conditions.MarkTrue(m, conditionInstanceProvisioned)
+	if err := r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Run the reconcile action for provisioners that support it and store any changes it reports
+// Failures are reported in the ProvisionerReconciled condition and do not stop the rest of the reconcile,
+// the InstanceProvisioned condition is left alone
+func (r *MachineReconciler) reconcileProvisionerState(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	if !provision.RunsOnReconcile(r.Provisioner) {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx)
+	name := r.Provisioner.Name()
+	res, err := r.Provisioner.Provision(ctx, provision.ActionReconcile, m)
+	if errors.Is(err, provision.ErrInProgress) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "Provisioner failed", "Provisioner", name, "Action", provision.ActionReconcile)
+		original := conditions.Get(m, conditionProvisionerReconciled)
+		msg := fmt.Sprintf("Provisioner %s failed to %s: %v", name, provision.ActionReconcile, err)
+		if original != nil && original.Status == corev1.ConditionFalse && original.Message == msg {
+			return ctrl.Result{}, nil
+		}
+		conditions.MarkFalse(m, conditionProvisionerReconciled, reasonProvisionFailed, machinev1.ConditionSeverityWarning, "%s", msg)
+		r.recordEvent(m, corev1.EventTypeWarning, "ProvisionFailed", "%s", msg)
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+	if c := conditions.Get(m, conditionProvisionerReconciled); c != nil && c.Status != corev1.ConditionTrue {
+		conditions.MarkTrue(m, conditionProvisionerReconciled)
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+	}
+	if !applyProvisionResult(m, res) {
+		return ctrl.Result{}, nil
+	}
+	logger.Info("Provisioner reported changes", "Provisioner", name, "Annotations", m.Annotations)
+	if err := r.Client.Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Run the delete hook for a machine being deleted
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcileDeprovision(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	if r.Provisioner == nil || !isLinkerManaged(m) || isConditionTrue(m, conditionInstanceDeprovisioned) {
+		return ctrl.Result{}, nil
+	}
+
+	_, err := r.runProvisioner(ctx, m, provision.ActionDelete, conditionInstanceDeprovisioned)
+	if err == nil {
+		conditions.MarkTrue(m, conditionInstanceDeprovisioned)
+	}
+	if uErr := r.Client.Status().Update(ctx, m); uErr != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", uErr)
+	}
+	if errors.Is(err, provision.ErrInProgress) {
+		return ctrl.Result{RequeueAfter: provisionRequeueAfter}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Run a provisioner action and record failures and progress in the condition
+// The condition is left for the caller to mark true once the result has been stored
+func (r *MachineReconciler) runProvisioner(ctx context.Context, m *machinev1.Machine, action provision.Action, conditionType machinev1.ConditionType) (*provision.Result, error) {
+	logger := log.FromContext(ctx)
+	name := r.Provisioner.Name()
+
+	logger.Info("Running provisioner", "Provisioner", name, "Action", action)
+	res, err := r.Provisioner.Provision(ctx, action, m)
+	switch {
+	case errors.Is(err, provision.ErrInProgress):
+		if c := conditions.Get(m, conditionType); c == nil || c.Reason != reasonProvisionInProgress {
+			r.recordEvent(m, corev1.EventTypeNormal, "ProvisionStarted", "Provisioner %s started %s", name, action)
+		}
+		conditions.MarkFalse(m, conditionType, reasonProvisionInProgress, machinev1.ConditionSeverityInfo, "Provisioner %s is running %s", name, action)
+		return nil, err
+	case err != nil:
+		logger.Error(err, "Provisioner failed", "Provisioner", name, "Action", action)
+		conditions.MarkFalse(m, conditionType, reasonProvisionFailed, machinev1.ConditionSeverityWarning, "Provisioner %s failed to %s: %v", name, action, err)
+		r.recordEvent(m, corev1.EventTypeWarning, "ProvisionFailed", "Provisioner %s failed to %s: %v", name, action, err)
+		return nil, fmt.Errorf("provisioner %s failed to %s: %w", name, action, err)
+	}
+	r.recordEvent(m, corev1.EventTypeNormal, "ProvisionSucceeded", "Provisioner %s finished %s", name, action)
+	return res, nil
+}
+
+// Store a provisioner result in the linker annotations so the usual address and
+// providerID handling picks it up
+// Returns true when the annotations changed
+func applyProvisionResult(m *machinev1.Machine, res *provision.Result) bool {
+	if res == nil {
+		return false
+	}
+	values := map[string]string{}
+	for _, addr := range res.Addresses {
+		var key string
+		switch addr.Type {
+		case corev1.NodeInternalIP:
+			key = InternalIPAnnotation
+		case corev1.NodeHostName:
+			key = HostnameAnnotation
+		case corev1.NodeInternalDNS:
+			key = InternalDNSAnnotation
+		default:
+			continue
+		}
+		// Annotations hold a single address of each type, the first one wins
+		if _, ok := values[key]; !ok {
+			values[key] = addr.Address
+		}
+	}
+	if res.ProviderID != nil {
+		values[ProviderIDAnnotation] = *res.ProviderID
+	}
+	if res.InstanceState != nil {
+		values[ProviderStateAnnotation] = *res.InstanceState
+	}
+
+	changed := false
+	for key, value := range values {
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		if current, ok := m.Annotations[getAnnotationKey(key)]; !ok || current != value {
+			m.Annotations[getAnnotationKey(key)] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+func isConditionTrue(m *machinev1.Machine, conditionType machinev1.ConditionType) bool {
+	c := conditions.Get(m, conditionType)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
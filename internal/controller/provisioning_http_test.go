@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Provisioning service", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		Token            = "s3cret"
+	)
+
+	var (
+		ctx           context.Context
+		r             *MachineReconciler
+		server        *httptest.Server
+		mu            sync.Mutex
+		requests      []provision.Request
+		pending       int
+		failReconcile bool
+		lookupKey     = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests = nil
+		pending = 1
+		failReconcile = false
+
+		// Stand-in provisioning service, accepts the first create and finishes it on the next request
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if req.Header.Get("Authorization") != "Bearer "+Token {
+				http.Error(w, "bad token", http.StatusUnauthorized)
+				return
+			}
+			body := provision.Request{}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			requests = append(requests, body)
+			switch {
+			case body.Action == provision.ActionCreate && pending > 0:
+				pending--
+				w.WriteHeader(http.StatusAccepted)
+			case body.Action == provision.ActionCreate:
+				w.Write([]byte(`{"addresses":[{"type":"InternalIP","address":"10.0.0.7"}],"instanceState":"running"}`))
+			case body.Action == provision.ActionReconcile && failReconcile:
+				http.Error(w, "inventory unavailable", http.StatusServiceUnavailable)
+			case body.Action == provision.ActionReconcile:
+				w.Write([]byte(`{"instanceState":"rebooting"}`))
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "provisioner", Namespace: "machine-node-linker"},
+			Data:       map[string][]byte{provision.SecretTokenKey: []byte(Token)},
+		}
+		r = newFakeMachineReconciler(&machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        MachineName,
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{getAnnotationKey(ManagedAnnotation): ""},
+			},
+		})
+		r.Provisioner = &provision.HTTPProvisioner{
+			URL:             server.URL,
+			KubeClient:      kubefake.NewSimpleClientset(secret),
+			SecretNamespace: secret.Namespace,
+			SecretName:      secret.Name,
+		}
+	})
+
+	It("Should wait for an accepted create and store the result", func() {
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(provisionRequeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Reason", reasonProvisionInProgress))
+
+		for i := 0; i < 10 && !res.IsZero(); i++ {
+			res, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: lookupKey})
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Status", corev1.ConditionTrue))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), "10.0.0.7"))
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.7"}))
+		Expect(requests).Should(HaveLen(2))
+		Expect(requests[1].Machine.Name).Should(Equal(MachineName))
+	})
+
+	It("Should apply changes reported by the reconcile action", func() {
+		r.Provisioner.(*provision.HTTPProvisioner).ReconcileAction = true
+		pending = 0
+
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(ProviderStateAnnotation), "rebooting"))
+		Expect(requests).Should(ContainElement(HaveField("Action", provision.ActionReconcile)))
+	})
+
+	It("Should keep reconciling the machine when the reconcile action fails", func() {
+		r.Provisioner.(*provision.HTTPProvisioner).ReconcileAction = true
+		pending = 0
+		failReconcile = true
+
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(provisionRequeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.7"}))
+		c := conditions.Get(m, conditionProvisionerReconciled)
+		Expect(c).ShouldNot(BeNil())
+		Expect(c.Status).Should(Equal(corev1.ConditionFalse))
+		Expect(c.Message).Should(ContainSubstring("inventory unavailable"))
+		Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Status", corev1.ConditionTrue))
+
+		By("Clearing the condition once the action succeeds")
+		failReconcile = false
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionProvisionerReconciled)).Should(HaveField("Status", corev1.ConditionTrue))
+	})
+
+	It("Should fail when the service rejects the credentials", func() {
+		r.Provisioner.(*provision.HTTPProvisioner).SecretName = ""
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).Should(MatchError(ContainSubstring("bad token")))
+	})
+})
@@ -0,0 +1,302 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Provisioning hooks", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+	)
+
+	var (
+		ctx       context.Context
+		r         *MachineReconciler
+		recorder  *record.FakeRecorder
+		dir       string
+		lookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	// Write a provision script that logs its action and input, then runs body
+	writeScript := func(body string) string {
+		path := filepath.Join(dir, "provision.sh")
+		script := "#!/bin/sh\necho \"$1 $MNL_ACTION\" >> " + filepath.Join(dir, "actions") + "\ncat > " + filepath.Join(dir, "input") + "\n" + body + "\n"
+		Expect(os.WriteFile(path, []byte(script), 0o755)).Should(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		dir = GinkgoT().TempDir()
+	})
+
+	newReconciler := func(command string, m *machinev1.Machine) {
+		r = newFakeMachineReconciler(m)
+		r.Provisioner = &provision.ExecProvisioner{Command: command, Timeout: 10 * time.Second}
+		recorder = r.Recorder.(*record.FakeRecorder)
+	}
+
+	// Reconcile until the hook running in the background has finished and check the machine
+	settle := func(check func(g Gomega, m *machinev1.Machine)) {
+		Eventually(func(g Gomega) {
+			_, err := reconcileUntilSettled(ctx, r, lookupKey)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			m := &machinev1.Machine{}
+			g.Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+			check(g, m)
+		}, 5*time.Second).Should(Succeed())
+	}
+
+	It("Should run the create hook and store its result", func() {
+		command := writeScript(`echo '{"addresses":[{"type":"InternalIP","address":"10.0.0.5"}],"providerID":"script:///test-machine","instanceState":"running"}'`)
+		newReconciler(command, &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        MachineName,
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{getAnnotationKey(ManagedAnnotation): ""},
+			},
+		})
+
+		m := &machinev1.Machine{}
+		settle(func(g Gomega, current *machinev1.Machine) {
+			g.Expect(isConditionTrue(current, conditionInstanceProvisioned)).Should(BeTrue())
+			m = current
+		})
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), "10.0.0.5"))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(ProviderStateAnnotation), "running"))
+		Expect(m.Spec.ProviderID).Should(HaveValue(Equal("script:///test-machine")))
+		Expect(m.Status.Addresses).Should(ContainElement(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}))
+		Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Status", corev1.ConditionTrue))
+		Expect(m.Finalizers).Should(ContainElement(NodeCleanupFinalizer))
+
+		input, err := os.ReadFile(filepath.Join(dir, "input"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(input)).Should(ContainSubstring(`"name":"test-machine"`))
+
+		By("Running the delete hook before releasing the finalizer")
+		Expect(r.Client.Delete(ctx, m)).Should(Succeed())
+		Eventually(func(g Gomega) {
+			_, err := reconcileUntilSettled(ctx, r, lookupKey)
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(apierrors.IsNotFound(r.Client.Get(ctx, lookupKey, m))).Should(BeTrue())
+		}, 5*time.Second).Should(Succeed())
+
+		actions, err := os.ReadFile(filepath.Join(dir, "actions"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(actions)).Should(Equal("create create\ndelete delete\n"))
+	})
+
+	It("Should run the reprovision hook and remove the annotation", func() {
+		command := writeScript(`echo '{"instanceState":"reinstalled"}'`)
+		newReconciler(command, &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				Annotations: map[string]string{
+					getAnnotationKey(InternalIPAnnotation):  "10.0.0.5",
+					getAnnotationKey(ReprovisionAnnotation): "",
+				},
+			},
+		})
+
+		settle(func(g Gomega, m *machinev1.Machine) {
+			g.Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(ReprovisionAnnotation)))
+			g.Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(ProviderStateAnnotation), "reinstalled"))
+			g.Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Status", corev1.ConditionTrue))
+		})
+	})
+
+	It("Should report a failing hook in the condition", func() {
+		command := writeScript("echo 'no free hosts' >&2\nexit 1")
+		newReconciler(command, &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        MachineName,
+				Namespace:   MachineNamespace,
+				Annotations: map[string]string{getAnnotationKey(ManagedAnnotation): ""},
+			},
+		})
+
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.RequeueAfter).Should(Equal(provisionRequeueAfter))
+		Eventually(func() error {
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: lookupKey})
+			return err
+		}, 5*time.Second).Should(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		c := conditions.Get(m, conditionInstanceProvisioned)
+		Expect(c).ShouldNot(BeNil())
+		Expect(c.Status).Should(Equal(corev1.ConditionFalse))
+		Expect(c.Reason).Should(Equal(reasonProvisionFailed))
+		Expect(c.Message).Should(ContainSubstring("no free hosts"))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("ProvisionStarted")))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("ProvisionFailed")))
+	})
+
+	It("Should not run the create hook for machines it does not manage", func() {
+		command := writeScript("")
+		newReconciler(command, &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: MachineName, Namespace: MachineNamespace},
+		})
+
+		_, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(BeNil())
+		Expect(filepath.Join(dir, "actions")).ShouldNot(BeAnExistingFile())
+	})
+
+	Context("With a Job template", func() {
+		var (
+			kubeClient *kubefake.Clientset
+			jobKey     = types.NamespacedName{Name: MachineName + "-create", Namespace: MachineNamespace}
+		)
+
+		BeforeEach(func() {
+			kubeClient = kubefake.NewSimpleClientset()
+			r = newFakeMachineReconciler(&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        MachineName,
+					Namespace:   MachineNamespace,
+					Annotations: map[string]string{getAnnotationKey(ManagedAnnotation): ""},
+				},
+			})
+			r.Provisioner = &provision.JobProvisioner{
+				KubeClient: kubeClient,
+				Template: &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{Name: "installer"},
+					Spec: batchv1.JobSpec{
+						Template: corev1.PodTemplateSpec{
+							Spec: corev1.PodSpec{
+								Containers: []corev1.Container{{Name: "install", Image: "installer:latest"}},
+							},
+						},
+					},
+				},
+				Timeout: 10 * time.Minute,
+				Retries: 3,
+			}
+		})
+
+		// Reconcile once the job has finished with the given condition
+		finishJob := func(conditionType batchv1.JobConditionType) {
+			job, err := kubeClient.BatchV1().Jobs(jobKey.Namespace).Get(ctx, jobKey.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: conditionType, Status: corev1.ConditionTrue})
+			_, err = kubeClient.BatchV1().Jobs(jobKey.Namespace).UpdateStatus(ctx, job, metav1.UpdateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		It("Should create the job with the machine, timeout and retries", func() {
+			res, err := reconcileUntilSettled(ctx, r, lookupKey)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.RequeueAfter).Should(Equal(provisionRequeueAfter))
+
+			job, err := kubeClient.BatchV1().Jobs(jobKey.Namespace).Get(ctx, jobKey.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(job.Labels).Should(HaveKeyWithValue(provision.JobMachineLabel, MachineName))
+			Expect(job.Labels).Should(HaveKeyWithValue(provision.JobActionLabel, string(provision.ActionCreate)))
+			Expect(job.Spec.BackoffLimit).Should(HaveValue(BeEquivalentTo(3)))
+			Expect(job.Spec.Template.Spec.ActiveDeadlineSeconds).Should(HaveValue(BeEquivalentTo(600)))
+			container := job.Spec.Template.Spec.Containers[0]
+			Expect(container.Env).Should(ContainElement(corev1.EnvVar{Name: provision.ActionEnvVar, Value: string(provision.ActionCreate)}))
+			Expect(container.Env).Should(ContainElement(HaveField("Name", provision.MachineEnvVar)))
+
+			m := &machinev1.Machine{}
+			Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+			Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Reason", reasonProvisionInProgress))
+		})
+
+		It("Should store the result of a finished job and delete it", func() {
+			_, err := reconcileUntilSettled(ctx, r, lookupKey)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = kubeClient.CoreV1().Pods(jobKey.Namespace).Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      jobKey.Name + "-abcde",
+					Namespace: jobKey.Namespace,
+					Labels:    map[string]string{"job-name": jobKey.Name},
+				},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodSucceeded,
+					ContainerStatuses: []corev1.ContainerStatus{{
+						Name: "install",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							Message: `{"addresses":[{"type":"InternalIP","address":"10.0.0.6"}],"instanceState":"installed"}`,
+						}},
+					}},
+				},
+			}, metav1.CreateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			finishJob(batchv1.JobComplete)
+
+			_, err = reconcileUntilSettled(ctx, r, lookupKey)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			m := &machinev1.Machine{}
+			Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+			Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Status", corev1.ConditionTrue))
+			Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), "10.0.0.6"))
+			Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(ProviderStateAnnotation), "installed"))
+			_, err = kubeClient.BatchV1().Jobs(jobKey.Namespace).Get(ctx, jobKey.Name, metav1.GetOptions{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+
+		It("Should report a failed job in the condition and delete it", func() {
+			_, err := reconcileUntilSettled(ctx, r, lookupKey)
+			Expect(err).ShouldNot(HaveOccurred())
+			finishJob(batchv1.JobFailed)
+
+			_, err = reconcileUntilSettled(ctx, r, lookupKey)
+			Expect(err).Should(MatchError(ContainSubstring("failed")))
+
+			m := &machinev1.Machine{}
+			Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+			Expect(conditions.Get(m, conditionInstanceProvisioned)).Should(HaveField("Reason", reasonProvisionFailed))
+			_, err = kubeClient.BatchV1().Jobs(jobKey.Namespace).Get(ctx, jobKey.Name, metav1.GetOptions{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+
+		It("Should keep the timeout and retries set in the template", func() {
+			template := r.Provisioner.(*provision.JobProvisioner).Template
+			template.Spec.BackoffLimit = ptr.To[int32](0)
+			template.Spec.Template.Spec.ActiveDeadlineSeconds = ptr.To[int64](60)
+
+			_, err := reconcileUntilSettled(ctx, r, lookupKey)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			job, err := kubeClient.BatchV1().Jobs(jobKey.Namespace).Get(ctx, jobKey.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(job.Spec.BackoffLimit).Should(HaveValue(BeEquivalentTo(0)))
+			Expect(job.Spec.Template.Spec.ActiveDeadlineSeconds).Should(HaveValue(BeEquivalentTo(60)))
+		})
+	})
+})
@@ -0,0 +1,312 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+const (
+	// Name of the Secret holding the one-time registration token of the machine
+	RegistrationSecretAnnotation = "registration-secret"
+	// Key of the token in the registration Secret, removed once the token is used
+	RegistrationTokenKey = "token"
+	// Type registration Secrets must have, other Secrets are never read or changed
+	RegistrationSecretType corev1.SecretType = "machine-node-linker.github.com/registration-token"
+	// Path hosts post their registration to
+	RegistrationPath = "/v1/register"
+
+	registrationMaxBody = 64 << 10
+)
+
+// Registration is the body hosts post to the registration server
+type Registration struct {
+	Namespace    string               `json:"namespace"`
+	Machine      string               `json:"machine"`
+	Hostname     string               `json:"hostname,omitempty"`
+	Addresses    []corev1.NodeAddress `json:"addresses,omitempty"`
+	MACAddresses []string             `json:"macAddresses,omitempty"`
+}
+
+// RegistrationServer lets hosts without a node report their addresses to their machine using a one-time token
+type RegistrationServer struct {
+	Client client.Client
+	// Reads the token Secrets, which are not cached by the manager
+	KubeClient kubernetes.Interface
+
+	// Namespace of the registration Secrets
+	SecretNamespace string
+
+	// Address the server listens on
+	Addr string
+	// Certificate and key served, required unless Insecure is set
+	CertFile string
+	KeyFile  string
+	// Serve plain HTTP, the tokens and addresses are sent in the clear
+	Insecure bool
+}
+
+var errRegistrationTLS = errors.New("registration server needs a TLS certificate and key unless insecure is set")
+
+// Start runs the server until the context is done
+func (s *RegistrationServer) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("registration")
+	useTLS := s.CertFile != "" && s.KeyFile != ""
+	if !useTLS && !s.Insecure {
+		return errRegistrationTLS
+	}
+	mux := http.NewServeMux()
+	mux.Handle(RegistrationPath, s)
+	srv := &http.Server{
+		Addr:              s.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return log.IntoContext(context.Background(), logger) },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Starting registration server", "Addr", s.Addr, "TLS", useTLS)
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS(s.CertFile, s.KeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// NeedLeaderElection is false so every replica of the manager serves registrations
+func (s *RegistrationServer) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP handles a registration
+// +kubebuilder:rbac:groups=,namespace=machine-node-linker,resources=secrets,verbs=get;update
+func (s *RegistrationServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.FromContext(ctx)
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	reg := &Registration{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, registrationMaxBody)).Decode(reg); err != nil {
+		http.Error(w, fmt.Sprintf("invalid registration: %v", err), http.StatusBadRequest)
+		return
+	}
+	res, macs, err := reg.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := apitypes.NamespacedName{Namespace: reg.Namespace, Name: reg.Machine}
+	secret, err := s.checkToken(ctx, key, token)
+	if err != nil {
+		if errors.Is(err, errInvalidToken) {
+			logger.Info("Rejected registration", "Machine", key.String())
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		logger.Error(err, "unable to check registration token", "Machine", key.String())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// The token is used up before the addresses are written, so a concurrent use of the same token cannot write them too
+	consumed, err := s.consumeToken(ctx, secret)
+	if err != nil {
+		if errors.Is(err, errInvalidToken) {
+			logger.Info("Token used concurrently", "Machine", key.String())
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		logger.Error(err, "unable to invalidate registration token", "Machine", key.String())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.annotate(ctx, key, res, macs); err != nil {
+		logger.Error(err, "unable to register machine", "Machine", key.String())
+		// Give the token back so the registration can be retried
+		if err := s.restoreToken(ctx, consumed, []byte(token)); err != nil {
+			logger.Error(err, "unable to restore registration token", "Machine", key.String())
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Registered machine", "Machine", key.String(), "Addresses", res.Addresses)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Check the fields of the registration and convert them to machine annotations
+func (reg *Registration) validate() (*provision.Result, []string, error) {
+	if reg.Namespace == "" || reg.Machine == "" {
+		return nil, nil, errors.New("namespace and machine are required")
+	}
+	res := &provision.Result{}
+	if reg.Hostname != "" {
+		res.Addresses = append(res.Addresses, corev1.NodeAddress{Type: corev1.NodeHostName, Address: reg.Hostname})
+	}
+	for _, addr := range reg.Addresses {
+		switch addr.Type {
+		case corev1.NodeInternalIP:
+			if net.ParseIP(addr.Address) == nil {
+				return nil, nil, fmt.Errorf("invalid InternalIP %q", addr.Address)
+			}
+		case corev1.NodeHostName, corev1.NodeInternalDNS:
+			if addr.Address == "" {
+				return nil, nil, fmt.Errorf("empty %s address", addr.Type)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported address type %q", addr.Type)
+		}
+		res.Addresses = append(res.Addresses, addr)
+	}
+	macs, err := parseMACAddresses(strings.Join(reg.MACAddresses, ","))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res.Addresses) == 0 && len(macs) == 0 {
+		return nil, nil, errors.New("no addresses to register")
+	}
+	return res, macs, nil
+}
+
+var errInvalidToken = errors.New("invalid registration token")
+
+// Check the token against the registration Secret named by the machine
+// Only Secrets of the registration type in the Secret namespace are used
+func (s *RegistrationServer) checkToken(ctx context.Context, key apitypes.NamespacedName, token string) (*corev1.Secret, error) {
+	m := &machinev1.Machine{}
+	if err := s.Client.Get(ctx, key, m); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, errInvalidToken
+		}
+		return nil, fmt.Errorf("unable to get machine: %w", err)
+	}
+	name := m.Annotations[getAnnotationKey(RegistrationSecretAnnotation)]
+	if name == "" {
+		return nil, errInvalidToken
+	}
+	secret, err := s.KubeClient.CoreV1().Secrets(s.SecretNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, errInvalidToken
+		}
+		return nil, fmt.Errorf("unable to get secret: %w", err)
+	}
+	if secret.Type != RegistrationSecretType {
+		return nil, errInvalidToken
+	}
+	expected, ok := secret.Data[RegistrationTokenKey]
+	if !ok || len(expected) == 0 || subtle.ConstantTimeCompare(expected, []byte(token)) != 1 {
+		return nil, errInvalidToken
+	}
+	return secret, nil
+}
+
+// Remove the token from the Secret so it can only be used once
+// The resourceVersion check makes a concurrent use of the same token fail
+func (s *RegistrationServer) consumeToken(ctx context.Context, secret *corev1.Secret) (*corev1.Secret, error) {
+	secret = secret.DeepCopy()
+	delete(secret.Data, RegistrationTokenKey)
+	consumed, err := s.KubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		if apierrors.IsConflict(err) {
+			return nil, errInvalidToken
+		}
+		return nil, fmt.Errorf("unable to invalidate token: %w", err)
+	}
+	return consumed, nil
+}
+
+// Put a consumed token back, unless the Secret was changed since
+func (s *RegistrationServer) restoreToken(ctx context.Context, consumed *corev1.Secret, token []byte) error {
+	secret := consumed.DeepCopy()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[RegistrationTokenKey] = token
+	if _, err := s.KubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to restore token: %w", err)
+	}
+	return nil
+}
+
+// Write the registered addresses to the machine annotations
+func (s *RegistrationServer) annotate(ctx context.Context, key apitypes.NamespacedName, res *provision.Result, macs []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		m := &machinev1.Machine{}
+		if err := s.Client.Get(ctx, key, m); err != nil {
+			return err
+		}
+		changed := applyProvisionResult(m, res)
+		if value := strings.Join(macs, ","); value != "" && m.Annotations[getAnnotationKey(MACAddressAnnotation)] != value {
+			if m.Annotations == nil {
+				m.Annotations = map[string]string{}
+			}
+			m.Annotations[getAnnotationKey(MACAddressAnnotation)] = value
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		return s.Client.Update(ctx, m)
+	})
+}
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Host registration", func() {
+
+	const (
+		MachineName      = "worker-0"
+		MachineNamespace = "openshift-machine-api"
+		SecretName       = "worker-0-registration"
+		SecretNamespace  = "machine-node-linker"
+		Token            = "s3cr3t"
+	)
+
+	var (
+		ctx       context.Context
+		s         *RegistrationServer
+		server    *httptest.Server
+		lookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		s = &RegistrationServer{
+			Client: newFakeClient(&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        MachineName,
+					Namespace:   MachineNamespace,
+					Annotations: map[string]string{getAnnotationKey(RegistrationSecretAnnotation): SecretName},
+				},
+			}),
+			KubeClient: kubefake.NewSimpleClientset(
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: SecretName, Namespace: SecretNamespace},
+					Type:       RegistrationSecretType,
+					Data:       map[string][]byte{RegistrationTokenKey: []byte(Token)},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: SecretNamespace},
+					Data:       map[string][]byte{RegistrationTokenKey: []byte(Token)},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: SecretName, Namespace: MachineNamespace},
+					Type:       RegistrationSecretType,
+					Data:       map[string][]byte{RegistrationTokenKey: []byte("other")},
+				},
+			),
+			SecretNamespace: SecretNamespace,
+		}
+		server = httptest.NewServer(s)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	register := func(token string, reg *Registration) int {
+		body, err := json.Marshal(reg)
+		Expect(err).ShouldNot(HaveOccurred())
+		req, err := http.NewRequest(http.MethodPost, server.URL+RegistrationPath, bytes.NewReader(body))
+		Expect(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := server.Client().Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	registration := func() *Registration {
+		return &Registration{
+			Namespace:    MachineNamespace,
+			Machine:      MachineName,
+			Hostname:     "worker-0.example.com",
+			Addresses:    []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.10"}},
+			MACAddresses: []string{"52:54:00:AA:BB:01"},
+		}
+	}
+
+	It("Should annotate the machine and invalidate the token", func() {
+		Expect(register(Token, registration())).Should(Equal(http.StatusNoContent))
+
+		m := &machinev1.Machine{}
+		Expect(s.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), "10.0.0.10"))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(HostnameAnnotation), "worker-0.example.com"))
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(MACAddressAnnotation), "52:54:00:aa:bb:01"))
+
+		secret, err := s.KubeClient.CoreV1().Secrets(SecretNamespace).Get(ctx, SecretName, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(secret.Data).ShouldNot(HaveKey(RegistrationTokenKey))
+
+		Expect(register(Token, registration())).Should(Equal(http.StatusForbidden))
+	})
+
+	It("Should reject a wrong token or an unknown machine", func() {
+		Expect(register("wrong", registration())).Should(Equal(http.StatusForbidden))
+		reg := registration()
+		reg.Machine = "other"
+		Expect(register(Token, reg)).Should(Equal(http.StatusForbidden))
+
+		m := &machinev1.Machine{}
+		Expect(s.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(InternalIPAnnotation)))
+	})
+
+	It("Should reject invalid addresses without using the token", func() {
+		reg := registration()
+		reg.Addresses[0].Address = "not-an-ip"
+		Expect(register(Token, reg)).Should(Equal(http.StatusBadRequest))
+		Expect(register(Token, registration())).Should(Equal(http.StatusNoContent))
+	})
+
+	It("Should only use registration Secrets in the Secret namespace", func() {
+		Expect(register("other", registration())).Should(Equal(http.StatusForbidden))
+
+		m := &machinev1.Machine{}
+		Expect(s.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		m.Annotations[getAnnotationKey(RegistrationSecretAnnotation)] = "opaque"
+		Expect(s.Client.Update(ctx, m)).Should(Succeed())
+		Expect(register(Token, registration())).Should(Equal(http.StatusForbidden))
+
+		secret, err := s.KubeClient.CoreV1().Secrets(SecretNamespace).Get(ctx, "opaque", metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(secret.Data).Should(HaveKey(RegistrationTokenKey))
+	})
+
+	It("Should keep the token when the machine cannot be annotated", func() {
+		m := &machinev1.Machine{}
+		Expect(s.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		s.Client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(m).WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(context.Context, client.WithWatch, client.Object, ...client.UpdateOption) error {
+				return errors.New("apiserver unavailable")
+			},
+		}).Build()
+		Expect(register(Token, registration())).Should(Equal(http.StatusInternalServerError))
+
+		secret, err := s.KubeClient.CoreV1().Secrets(SecretNamespace).Get(ctx, SecretName, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(secret.Data).Should(HaveKey(RegistrationTokenKey))
+	})
+
+	It("Should only accept one of two concurrent registrations with the same token", func() {
+		By("Checking Secret resource versions on update like the API server")
+		kube := s.KubeClient.(*kubefake.Clientset)
+		secrets := corev1.SchemeGroupVersion.WithResource("secrets")
+		kube.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			secret := action.(k8stesting.UpdateAction).GetObject().(*corev1.Secret).DeepCopy()
+			current, err := kube.Tracker().Get(secrets, secret.Namespace, secret.Name)
+			if err != nil {
+				return true, nil, err
+			}
+			if current.(*corev1.Secret).ResourceVersion != secret.ResourceVersion {
+				return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, errors.New("the object has been modified"))
+			}
+			version, _ := strconv.Atoi(secret.ResourceVersion)
+			secret.ResourceVersion = strconv.Itoa(version + 1)
+			return true, secret, kube.Tracker().Update(secrets, secret, secret.Namespace)
+		})
+
+		By("Letting both requests check the token before either uses it")
+		m := &machinev1.Machine{}
+		Expect(s.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		var checking sync.WaitGroup
+		var gets atomic.Int32
+		checking.Add(2)
+		s.Client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(m).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if gets.Add(1) <= 2 {
+					checking.Done()
+					checking.Wait()
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).Build()
+
+		regs := []*Registration{registration(), registration()}
+		regs[1].Addresses[0].Address = "10.0.0.11"
+		codes := make([]int, len(regs))
+		var done sync.WaitGroup
+		for i := range regs {
+			done.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer done.Done()
+				codes[i] = register(Token, regs[i])
+			}(i)
+		}
+		done.Wait()
+		Expect(codes).Should(ConsistOf(http.StatusNoContent, http.StatusForbidden))
+
+		winner := regs[0]
+		if codes[1] == http.StatusNoContent {
+			winner = regs[1]
+		}
+		Expect(s.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(InternalIPAnnotation), winner.Addresses[0].Address))
+	})
+
+	It("Should not serve plain HTTP unless insecure is set", func() {
+		s.Addr = "127.0.0.1:0"
+		Expect(s.Start(ctx)).Should(MatchError(errRegistrationTLS))
+	})
+})
@@ -0,0 +1,253 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	remediationv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/remediation/v1alpha1"
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+const (
+	// How often a running Reprovision remediation checks the machine
+	remediationRequeueAfter = 30 * time.Second
+	// How long a Reprovision remediation waits for the new node by default
+	defaultReprovisionTimeout = time.Hour
+)
+
+// LinkerRemediationReconciler remediates unhealthy machines for the LinkerRemediations created by MachineHealthChecks
+type LinkerRemediationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Provides the provisioner and BMC access of the machines
+	Machines *MachineReconciler
+	// How long a Reprovision remediation waits for the machine to be linked to a new node before it fails
+	ReprovisionTimeout time.Duration
+	// Fail Reprovision remediations, the reprovision annotation is only handled by the machine reconciler
+	DisableReprovision bool
+
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=remediation.machine-node-linker.github.com,resources=linkerremediations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=remediation.machine-node-linker.github.com,resources=linkerremediations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=remediation.machine-node-linker.github.com,resources=linkerremediationtemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch;update;patch
+func (r *LinkerRemediationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rem := &remediationv1alpha1.LinkerRemediation{}
+	if err := r.Client.Get(ctx, req.NamespacedName, rem); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get remediation: %v", err)
+	}
+	// The MachineHealthCheck deletes the remediation once the machine is healthy
+	if !rem.DeletionTimestamp.IsZero() || rem.Status.CompletionTime != nil {
+		return ctrl.Result{}, nil
+	}
+
+	m := &machinev1.Machine{}
+	if err := r.Client.Get(ctx, req.NamespacedName, m); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "Machine %q not found", req.Name)
+		}
+		return ctrl.Result{}, fmt.Errorf("unable to get machine: %v", err)
+	}
+
+	if rem.Status.StartTime == nil {
+		now := metav1.Now()
+		rem.Status.StartTime = &now
+		rem.Status.Phase = remediationv1alpha1.RemediationPhaseRunning
+		rem.Status.Message = fmt.Sprintf("Running %s remediation", strategyOf(rem))
+		if err := r.Client.Status().Update(ctx, rem); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update remediation: %w", err)
+		}
+		logger.Info("Remediating machine", "Machine", m.Name, "Strategy", strategyOf(rem))
+		r.recordEvent(rem, corev1.EventTypeNormal, "RemediationStarted", "Running %s remediation", strategyOf(rem))
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	switch strategyOf(rem) {
+	case remediationv1alpha1.RemediationStrategyHook:
+		return r.remediateHook(ctx, rem, m)
+	case remediationv1alpha1.RemediationStrategyBMCReset:
+		return r.remediateBMCReset(ctx, rem, m)
+	case remediationv1alpha1.RemediationStrategyReprovision:
+		return r.remediateReprovision(ctx, rem, m)
+	case remediationv1alpha1.RemediationStrategyMark:
+		r.recordEvent(m, corev1.EventTypeWarning, "RemediationRequested", "Machine %s needs manual remediation", m.Name)
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseSucceeded, "Machine marked for manual remediation")
+	default:
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "Unknown strategy %q", rem.Spec.Strategy)
+	}
+}
+
+// Run the remediate action of the provisioner and apply its result to the machine
+func (r *LinkerRemediationReconciler) remediateHook(ctx context.Context, rem *remediationv1alpha1.LinkerRemediation, m *machinev1.Machine) (ctrl.Result, error) {
+	if r.Machines.Provisioner == nil {
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "No provisioner configured")
+	}
+	name := r.Machines.Provisioner.Name()
+	res, err := r.Machines.Provisioner.Provision(ctx, provision.ActionRemediate, m)
+	switch {
+	case errors.Is(err, provision.ErrInProgress):
+		return ctrl.Result{RequeueAfter: provisionRequeueAfter}, nil
+	case err != nil:
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "Provisioner %s failed to %s: %v", name, provision.ActionRemediate, err)
+	}
+	if applyProvisionResult(m, res) {
+		if err := r.Client.Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update machine: %w", err)
+		}
+	}
+	return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseSucceeded, "Provisioner %s finished %s", name, provision.ActionRemediate)
+}
+
+// Power cycle the host through its BMC, waiting while that would break etcd quorum
+// The host is reset at most once, even when the remediation is reconciled again before its result is recorded
+func (r *LinkerRemediationReconciler) remediateBMCReset(ctx context.Context, rem *remediationv1alpha1.LinkerRemediation, m *machinev1.Machine) (ctrl.Result, error) {
+	if !hasBMC(m) {
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "Machine has no %s annotation", getAnnotationKey(BMCAddressAnnotation))
+	}
+	if rem.Status.ResetTime != nil {
+		// The result of the reset was not recorded, do not reset the host a second time
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseSucceeded, "Host reset through its BMC at %s", rem.Status.ResetTime.UTC().Format(time.RFC3339))
+	}
+	if res, err := r.Machines.guardEtcdQuorum(ctx, m, "BMC reset remediation"); err != nil || !res.IsZero() {
+		return res, err
+	}
+	// Record the reset before running it, the update fails on a stale copy of the remediation
+	now := metav1.Now()
+	rem.Status.ResetTime = &now
+	if err := r.Client.Status().Update(ctx, rem); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update remediation: %w", err)
+	}
+	if err := r.Machines.powerAction(ctx, m, PowerActionReset); err != nil {
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "Unable to reset host: %v", err)
+	}
+	return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseSucceeded, "Host reset through its BMC")
+}
+
+// Request a reprovision of the machine and wait for it to be linked to a new node
+// Fails once ReprovisionTimeout has passed since the remediation started
+func (r *LinkerRemediationReconciler) remediateReprovision(ctx context.Context, rem *remediationv1alpha1.LinkerRemediation, m *machinev1.Machine) (ctrl.Result, error) {
+	if r.DisableReprovision {
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "The %s strategy is not supported with the machine actuator", remediationv1alpha1.RemediationStrategyReprovision)
+	}
+	c := conditions.Get(m, conditionReprovisioned)
+	if c != nil && c.Status == corev1.ConditionTrue && !c.LastTransitionTime.Before(rem.Status.StartTime) {
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseSucceeded, "Machine reprovisioned")
+	}
+	timeout := r.ReprovisionTimeout
+	if timeout <= 0 {
+		timeout = defaultReprovisionTimeout
+	}
+	remaining := time.Until(rem.Status.StartTime.Add(timeout))
+	if remaining <= 0 {
+		return ctrl.Result{}, r.complete(ctx, rem, remediationv1alpha1.RemediationPhaseFailed, "Machine not reprovisioned within %v", timeout)
+	}
+	if _, requested := m.Annotations[getAnnotationKey(ReprovisionAnnotation)]; !requested && (c == nil || c.Status == corev1.ConditionTrue) {
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[getAnnotationKey(ReprovisionAnnotation)] = ""
+		if err := r.Client.Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update machine: %w", err)
+		}
+		r.recordEvent(rem, corev1.EventTypeNormal, "ReprovisionRequested", "Requested reprovision of machine %s", m.Name)
+	}
+	return ctrl.Result{RequeueAfter: min(remediationRequeueAfter, remaining)}, nil
+}
+
+// Record the final phase of the remediation
+func (r *LinkerRemediationReconciler) complete(ctx context.Context, rem *remediationv1alpha1.LinkerRemediation, phase remediationv1alpha1.RemediationPhase, format string, args ...interface{}) error {
+	now := metav1.Now()
+	if rem.Status.StartTime == nil {
+		rem.Status.StartTime = &now
+	}
+	rem.Status.CompletionTime = &now
+	rem.Status.Phase = phase
+	rem.Status.Message = fmt.Sprintf(format, args...)
+	if err := r.Client.Status().Update(ctx, rem); err != nil {
+		return fmt.Errorf("unable to update remediation: %w", err)
+	}
+	eventType := corev1.EventTypeNormal
+	if phase == remediationv1alpha1.RemediationPhaseFailed {
+		eventType = corev1.EventTypeWarning
+	}
+	r.recordEvent(rem, eventType, "Remediation"+string(phase), "%s", rem.Status.Message)
+	return nil
+}
+
+func (r *LinkerRemediationReconciler) recordEvent(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+	}
+}
+
+// The strategy of the remediation, defaulted when the CRD default was not applied
+func strategyOf(rem *remediationv1alpha1.LinkerRemediation) remediationv1alpha1.RemediationStrategy {
+	if rem.Spec.Strategy == "" {
+		return remediationv1alpha1.RemediationStrategyMark
+	}
+	return rem.Spec.Strategy
+}
+
+// Map a machine to the remediation of the same name
+func machineToRemediation(_ context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: apitypes.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LinkerRemediationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("machine-node-linker")
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&remediationv1alpha1.LinkerRemediation{}).
+		Watches(&machinev1.Machine{}, handler.EnqueueRequestsFromMapFunc(machineToRemediation)).
+		Complete(r)
+}
@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubectl/pkg/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	remediationv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/remediation/v1alpha1"
+	"github.com/machine-node-linker/machine-node-linker/internal/provision"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("External remediation", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		SecretName       = "test-machine-bmc"
+	)
+
+	var (
+		ctx        context.Context
+		r          *LinkerRemediationReconciler
+		recorder   *record.FakeRecorder
+		rawMachine *machinev1.Machine
+		lookupKey  = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		rawMachine = &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        MachineName,
+				Namespace:   MachineNamespace,
+				UID:         "machine-uid",
+				Annotations: map[string]string{getAnnotationKey(InternalIPAnnotation): "10.0.0.5"},
+			},
+		}
+	})
+
+	newReconciler := func(strategy remediationv1alpha1.RemediationStrategy, objs ...client.Object) {
+		machines := newFakeMachineReconciler(append(objs, &remediationv1alpha1.LinkerRemediation{
+			ObjectMeta: metav1.ObjectMeta{Name: MachineName, Namespace: MachineNamespace},
+			Spec:       remediationv1alpha1.LinkerRemediationSpec{Strategy: strategy},
+		})...)
+		machines.KubeClient = kubefake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(100)
+		r = &LinkerRemediationReconciler{
+			Client:   machines.Client,
+			Scheme:   scheme.Scheme,
+			Machines: machines,
+			Recorder: recorder,
+		}
+	}
+
+	reconcile := func() ctrl.Result {
+		res, err := reconcileUntilSettled(ctx, r, lookupKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	remediation := func() *remediationv1alpha1.LinkerRemediation {
+		rem := &remediationv1alpha1.LinkerRemediation{}
+		Expect(r.Client.Get(ctx, lookupKey, rem)).Should(Succeed())
+		return rem
+	}
+
+	It("Should mark the machine and succeed", func() {
+		newReconciler("", rawMachine)
+		Expect(reconcile()).Should(BeZero())
+
+		rem := remediation()
+		Expect(rem.Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseSucceeded))
+		Expect(rem.Status.StartTime).ShouldNot(BeNil())
+		Expect(rem.Status.CompletionTime).ShouldNot(BeNil())
+		Expect(recorder.Events).Should(Receive(ContainSubstring("RemediationStarted")))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("RemediationRequested")))
+	})
+
+	It("Should fail when the machine is missing", func() {
+		newReconciler(remediationv1alpha1.RemediationStrategyMark)
+		reconcile()
+
+		rem := remediation()
+		Expect(rem.Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseFailed))
+		Expect(rem.Status.Message).Should(ContainSubstring("not found"))
+	})
+
+	It("Should reset the host through its BMC", func() {
+		mock := newRedfishMock("admin", "password")
+		defer mock.server.Close()
+		rawMachine.Annotations[getAnnotationKey(BMCAddressAnnotation)] = mock.address()
+		rawMachine.Annotations[getAnnotationKey(BMCCredentialsAnnotation)] = SecretName
+		newReconciler(remediationv1alpha1.RemediationStrategyBMCReset, rawMachine)
+		r.Machines.KubeClient = kubefake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: SecretName, Namespace: MachineNamespace},
+			Type:       BMCCredentialsSecretType,
+			Data:       map[string][]byte{BMCUsernameKey: []byte("admin"), BMCPasswordKey: []byte("password")},
+		})
+		reconcile()
+
+		Expect(mock.resetTypes()).Should(Equal([]string{"ForceRestart"}))
+		Expect(remediation().Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseSucceeded))
+	})
+
+	It("Should not reset the host again once the reset is recorded", func() {
+		mock := newRedfishMock("admin", "password")
+		defer mock.server.Close()
+		rawMachine.Annotations[getAnnotationKey(BMCAddressAnnotation)] = mock.address()
+		newReconciler(remediationv1alpha1.RemediationStrategyBMCReset, rawMachine)
+		rem := remediation()
+		started := metav1.Now()
+		rem.Status.Phase = remediationv1alpha1.RemediationPhaseRunning
+		rem.Status.StartTime = &started
+		rem.Status.ResetTime = &started
+		Expect(r.Client.Status().Update(ctx, rem)).Should(Succeed())
+		reconcile()
+
+		Expect(mock.resetTypes()).Should(BeEmpty())
+		Expect(remediation().Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseSucceeded))
+	})
+
+	It("Should reset the host through its BMC with the actuator", func() {
+		mock := newRedfishMock("admin", "password")
+		defer mock.server.Close()
+		rawMachine.Annotations[getAnnotationKey(BMCAddressAnnotation)] = mock.address()
+		rawMachine.Annotations[getAnnotationKey(BMCCredentialsAnnotation)] = SecretName
+		newReconciler(remediationv1alpha1.RemediationStrategyBMCReset, rawMachine)
+		r.DisableReprovision = true
+
+		By("Setting up the machine reconciler as the actuator, against an API server only serving the BMC credentials")
+		apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/api/v1/namespaces/"+MachineNamespace+"/secrets/"+SecretName {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Name: SecretName, Namespace: MachineNamespace},
+				Type:       BMCCredentialsSecretType,
+				Data:       map[string][]byte{BMCUsernameKey: []byte("admin"), BMCPasswordKey: []byte("password")},
+			})
+		}))
+		defer apiserver.Close()
+		mgr, err := ctrl.NewManager(&rest.Config{Host: apiserver.URL}, ctrl.Options{
+			Scheme:  scheme.Scheme,
+			Metrics: metricsserver.Options{BindAddress: "0"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		r.Machines.KubeClient = nil
+		Expect(r.Machines.SetupActuatorWithManager(mgr)).Should(Succeed())
+		reconcile()
+
+		Expect(mock.resetTypes()).Should(Equal([]string{"ForceRestart"}))
+		rem := remediation()
+		Expect(rem.Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseSucceeded))
+		Expect(rem.Status.ResetTime).ShouldNot(BeNil())
+	})
+
+	It("Should hold a BMC reset of a control plane machine while etcd would lose quorum", func() {
+		mock := newRedfishMock("admin", "password")
+		defer mock.server.Close()
+		rawMachine.Labels = map[string]string{MachineRoleLabel: "master"}
+		rawMachine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "test-node"}
+		rawMachine.Annotations[getAnnotationKey(BMCAddressAnnotation)] = mock.address()
+		newReconciler(remediationv1alpha1.RemediationStrategyBMCReset, rawMachine)
+		r.Machines.GuardControlPlane = true
+		r.Machines.KubeClient = kubefake.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-test-node", Namespace: etcdNamespace, Labels: map[string]string{"app": "etcd"}},
+			Spec:       corev1.PodSpec{NodeName: "test-node"},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+		})
+		res := reconcile()
+
+		Expect(res.RequeueAfter).Should(Equal(etcdGuardRequeueAfter))
+		Expect(mock.resetTypes()).Should(BeEmpty())
+		Expect(remediation().Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseRunning))
+	})
+
+	It("Should fail a BMC reset without a BMC", func() {
+		newReconciler(remediationv1alpha1.RemediationStrategyBMCReset, rawMachine)
+		reconcile()
+
+		rem := remediation()
+		Expect(rem.Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseFailed))
+		Expect(rem.Status.Message).Should(ContainSubstring(BMCAddressAnnotation))
+	})
+
+	It("Should run the remediate hook", func() {
+		dir := GinkgoT().TempDir()
+		command := filepath.Join(dir, "remediate.sh")
+		Expect(os.WriteFile(command, []byte("#!/bin/sh\necho \"$MNL_ACTION\" > "+filepath.Join(dir, "action")+"\necho '{\"instanceState\":\"remediated\"}'\n"), 0o755)).Should(Succeed())
+		newReconciler(remediationv1alpha1.RemediationStrategyHook, rawMachine)
+		r.Machines.Provisioner = &provision.ExecProvisioner{Command: command, Timeout: 10 * time.Second}
+		Eventually(func() remediationv1alpha1.RemediationPhase {
+			reconcile()
+			return remediation().Status.Phase
+		}, 5*time.Second).Should(Equal(remediationv1alpha1.RemediationPhaseSucceeded))
+		action, err := os.ReadFile(filepath.Join(dir, "action"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(action)).Should(Equal("remediate\n"))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKeyWithValue(getAnnotationKey(ProviderStateAnnotation), "remediated"))
+	})
+
+	It("Should request a reprovision and wait for it", func() {
+		newReconciler(remediationv1alpha1.RemediationStrategyReprovision, rawMachine)
+		res := reconcile()
+		Expect(res.RequeueAfter).Should(Equal(remediationRequeueAfter))
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).Should(HaveKey(getAnnotationKey(ReprovisionAnnotation)))
+		Expect(remediation().Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseRunning))
+	})
+
+	It("Should fail a reprovision that does not finish in time", func() {
+		newReconciler(remediationv1alpha1.RemediationStrategyReprovision, rawMachine)
+		r.ReprovisionTimeout = time.Nanosecond
+		reconcile()
+
+		rem := remediation()
+		Expect(rem.Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseFailed))
+		Expect(rem.Status.Message).Should(ContainSubstring("not reprovisioned within"))
+	})
+
+	It("Should fail a reprovision with the machine actuator", func() {
+		newReconciler(remediationv1alpha1.RemediationStrategyReprovision, rawMachine)
+		r.DisableReprovision = true
+		reconcile()
+
+		Expect(remediation().Status.Phase).Should(Equal(remediationv1alpha1.RemediationPhaseFailed))
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(ReprovisionAnnotation)))
+	})
+})
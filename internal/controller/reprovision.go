@@ -0,0 +1,141 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Machine condition tracking a reprovision from removing the old node to linking the new one
+	conditionReprovisioned      machinev1.ConditionType = "Reprovisioned"
+	reasonReprovisionInProgress                         = "ReprovisionInProgress"
+)
+
+// Handle the reprovision annotation
+// The old node is cordoned and deleted, the nodeRef cleared and the phase and providerStatus reset.
+// The annotation is then left for the reprovision hook when a provisioner is configured, or removed,
+// and the machine waits for the new node to be linked.
+// Returns a non-zero result when the caller must stop and wait
+func (r *MachineReconciler) reconcileReprovision(ctx context.Context, m *machinev1.Machine) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	_, requested := m.Annotations[getAnnotationKey(ReprovisionAnnotation)]
+
+	if !requested {
+		// Finished once the new node is linked
+		if c := conditions.Get(m, conditionReprovisioned); c != nil && c.Status != corev1.ConditionTrue && m.Status.NodeRef != nil {
+			conditions.MarkTrue(m, conditionReprovisioned)
+			r.recordEvent(m, corev1.EventTypeNormal, "Reprovisioned", "Linked to new node %q", m.Status.NodeRef.Name)
+			if err := r.Client.Status().Update(ctx, m); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+			}
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if m.Status.NodeRef != nil {
+		if res, err := r.guardEtcdQuorum(ctx, m, "Reprovision"); err != nil || !res.IsZero() {
+			return res, err
+		}
+
+		nodeName := m.Status.NodeRef.Name
+		logger.Info("Removing node for reprovision", "Node", nodeName)
+		if err := r.cordonNode(ctx, nodeName); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.deleteNode(ctx, nodeName); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.recordEvent(m, corev1.EventTypeNormal, "ReprovisionStarted", "Deleted node %q for reprovision", nodeName)
+
+		m.Status.NodeRef = nil
+		if _, ok := m.Annotations[getAnnotationKey(PhaseAnnotation)]; ok {
+			phase := phaseProvisioned
+			m.Status.Phase = &phase
+		}
+		if ps, err := providerStatusFromRawExtension(m.Status.ProviderStatus); err == nil && ps.isOurs() {
+			m.Status.ProviderStatus = nil
+		}
+		conditions.MarkFalse(m, conditionReprovisioned, reasonReprovisionInProgress, machinev1.ConditionSeverityInfo, "Removed node %q", nodeName)
+		if err := r.Client.Status().Update(ctx, m); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// The reprovision hook removes the annotation once it has run
+	if r.Provisioner != nil {
+		if c := conditions.Get(m, conditionReprovisioned); c == nil || c.Status == corev1.ConditionTrue {
+			conditions.MarkFalse(m, conditionReprovisioned, reasonReprovisionInProgress, machinev1.ConditionSeverityInfo, "Waiting for the reprovision hook")
+			if err := r.Client.Status().Update(ctx, m); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+			}
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	delete(m.Annotations, getAnnotationKey(ReprovisionAnnotation))
+	if err := r.Client.Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	conditions.MarkFalse(m, conditionReprovisioned, reasonReprovisionInProgress, machinev1.ConditionSeverityInfo, "Waiting for a new node")
+	if err := r.Client.Status().Update(ctx, m); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update client: %w", err)
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// Mark the node unschedulable, ignoring nodes that are already gone
+func (r *MachineReconciler) cordonNode(ctx context.Context, nodeName string) error {
+	n := &corev1.Node{}
+	if err := r.Client.Get(ctx, apitypes.NamespacedName{Name: nodeName}, n); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get node: %v", err)
+	}
+	if n.Spec.Unschedulable {
+		return nil
+	}
+	patch := client.MergeFrom(n.DeepCopy())
+	n.Spec.Unschedulable = true
+	if err := r.Client.Patch(ctx, n, patch); err != nil {
+		return fmt.Errorf("unable to cordon node: %w", err)
+	}
+	return nil
+}
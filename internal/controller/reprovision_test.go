@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Reprovisioning", func() {
+
+	const (
+		MachineName      = "test-machine"
+		MachineNamespace = "openshift-machine-api"
+		OldNodeName      = "old-node"
+		NewNodeName      = "new-node"
+	)
+
+	var (
+		ctx       context.Context
+		r         *MachineReconciler
+		lookupKey = types.NamespacedName{Name: MachineName, Namespace: MachineNamespace}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		phase := phaseFailed
+		rawMachine := &machinev1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      MachineName,
+				Namespace: MachineNamespace,
+				Annotations: map[string]string{
+					getAnnotationKey(InternalIPAnnotation):    "10.0.0.5",
+					getAnnotationKey(ProviderStateAnnotation): "running",
+					getAnnotationKey(PhaseAnnotation):         "",
+					getAnnotationKey(ReprovisionAnnotation):   "",
+				},
+			},
+			Status: machinev1.MachineStatus{
+				Phase:   &phase,
+				NodeRef: &corev1.ObjectReference{Kind: "Node", Name: OldNodeName},
+			},
+		}
+		r = newFakeMachineReconciler(rawMachine, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: OldNodeName}})
+	})
+
+	It("Should remove the old node and wait for the new one", func() {
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		m := &machinev1.Machine{}
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(apierrors.IsNotFound(r.Client.Get(ctx, types.NamespacedName{Name: OldNodeName}, &corev1.Node{}))).Should(BeTrue())
+		Expect(m.Annotations).ShouldNot(HaveKey(getAnnotationKey(ReprovisionAnnotation)))
+		Expect(m.Status.NodeRef).Should(BeNil())
+		Expect(m.Status.Phase).Should(HaveValue(Equal(phaseProvisioned)))
+		Expect(conditions.Get(m, conditionReprovisioned)).Should(HaveField("Status", corev1.ConditionFalse))
+
+		By("Linking the new node")
+		Expect(r.Client.Create(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: NewNodeName}})).Should(Succeed())
+		m.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: NewNodeName}
+		Expect(r.Client.Status().Update(ctx, m)).Should(Succeed())
+		Expect(reconcileUntilSettled(ctx, r, lookupKey)).Error().ShouldNot(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, lookupKey, m)).Should(Succeed())
+		Expect(conditions.Get(m, conditionReprovisioned)).Should(HaveField("Status", corev1.ConditionTrue))
+		Expect(m.Status.Phase).Should(HaveValue(Equal(phaseRunning)))
+	})
+})
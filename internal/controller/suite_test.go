@@ -41,13 +41,20 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	inventoryv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/inventory/v1alpha1"
+	remediationv1alpha1 "github.com/machine-node-linker/machine-node-linker/api/remediation/v1alpha1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -64,6 +71,9 @@ var (
 	ctx       context.Context
 	cancel    context.CancelFunc
 	syncTime  = interval
+
+	// Address inventory of the manager, created by the specs that use it
+	testAddressInventory = types.NamespacedName{Namespace: "machine-node-linker", Name: "address-inventory"}
 )
 
 func TestMachineController(t *testing.T) {
@@ -83,6 +93,7 @@ var _ = BeforeSuite(func() {
 	testEnv = &envtest.Environment{
 		CRDDirectoryPaths: []string{
 			filepath.Join(build.Default.GOPATH, "pkg", "mod", "github.com", "openshift", "api@v0.0.0-20240124164020-e2ce40831f2e", "machine", "v1beta1"),
+			filepath.Join("..", "..", "config", "crd", "bases"),
 		},
 		ErrorIfCRDPathMissing: false,
 	}
@@ -95,6 +106,10 @@ var _ = BeforeSuite(func() {
 
 	err = machinev1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = inventoryv1alpha1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = remediationv1alpha1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
@@ -109,10 +124,16 @@ var _ = BeforeSuite(func() {
 		},
 	})
 	Expect(err).ToNot(HaveOccurred())
+	Expect(IndexMachineFields(ctx, k8sManager)).Should(Succeed())
 
 	err = (&MachineReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:          k8sManager.GetClient(),
+		Scheme:          k8sManager.GetScheme(),
+		HeartbeatLeases: true,
+		AddressInventory: &AddressInventory{
+			Kind:           AddressInventoryConfigMap,
+			NamespacedName: testAddressInventory,
+		},
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 	err = (&NodeReconciler{
@@ -136,6 +157,11 @@ var _ = BeforeSuite(func() {
 			Name: "openshift-machine-api",
 		},
 	})).Should(Succeed())
+	Expect(k8sClient.Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testAddressInventory.Namespace,
+		},
+	})).Should(Succeed())
 })
 
 var _ = AfterSuite(func() {
@@ -144,3 +170,43 @@ var _ = AfterSuite(func() {
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// Fake client for specs that call a reconciler directly instead of going through the manager
+func newFakeClient(objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(objs...).
+		WithIndex(&machinev1.Machine{}, machineNodeRefIndex, machineNodeRefName).
+		WithIndex(&machinev1.Machine{}, machineNodeNameIndex, machineNodeName).
+		WithIndex(&machinev1.Machine{}, machineProviderIDIndex, machineProviderID).
+		WithIndex(&machinev1.Machine{}, machineMACAddressIndex, unlinkedMachineMACAddresses).
+		WithStatusSubresource(
+			&machinev1.Machine{},
+			&inventoryv1alpha1.Host{},
+			&inventoryv1alpha1.HostPool{},
+			&remediationv1alpha1.LinkerRemediation{},
+		).
+		Build()
+}
+
+// MachineReconciler on a fake client, specs set the options they exercise
+func newFakeMachineReconciler(objs ...client.Object) *MachineReconciler {
+	return &MachineReconciler{
+		Client:   newFakeClient(objs...),
+		Scheme:   scheme.Scheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// Run a reconciler until it fails or stops asking to be requeued immediately
+func reconcileUntilSettled(ctx context.Context, r reconcile.Reconciler, key types.NamespacedName) (ctrl.Result, error) {
+	var res ctrl.Result
+	var err error
+	for i := 0; i < 10; i++ {
+		res, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		if err != nil || !res.Requeue {
+			break
+		}
+	}
+	return res, err
+}
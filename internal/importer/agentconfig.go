@@ -0,0 +1,454 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+// Package importer builds machine-node-linker objects from existing inventory descriptions
+package importer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/annotations"
+	"github.com/machine-node-linker/machine-node-linker/internal/controller"
+)
+
+const (
+	roleMaster = "master"
+	roleWorker = "worker"
+
+	// Labels set on machines by the installer and MachineSets
+	clusterLabel     = "machine.openshift.io/cluster-api-cluster"
+	machineTypeLabel = "machine.openshift.io/cluster-api-machine-type"
+	machineSetLabel  = "machine.openshift.io/cluster-api-machineset"
+)
+
+// AgentConfig is the part of an agent-config.yaml describing the hosts
+type AgentConfig struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Hosts    []AgentHost       `json:"hosts"`
+}
+
+// AgentHost is a host of an agent-config.yaml
+type AgentHost struct {
+	Hostname      string           `json:"hostname"`
+	Role          string           `json:"role"`
+	Interfaces    []AgentInterface `json:"interfaces"`
+	NetworkConfig NetworkConfig    `json:"networkConfig"`
+}
+
+// AgentInterface maps an interface name of the host to its MAC address
+type AgentInterface struct {
+	Name       string `json:"name"`
+	MACAddress string `json:"macAddress"`
+}
+
+// NetworkConfig is the nmstate configuration of a host
+type NetworkConfig struct {
+	Interfaces []NMStateInterface `json:"interfaces"`
+}
+
+// NMStateInterface is an interface of an nmstate configuration
+type NMStateInterface struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	State      string    `json:"state"`
+	MACAddress string    `json:"mac-address"`
+	IPv4       NMStateIP `json:"ipv4"`
+	IPv6       NMStateIP `json:"ipv6"`
+}
+
+// NMStateIP is the ipv4 or ipv6 configuration of an nmstate interface
+type NMStateIP struct {
+	Enabled bool               `json:"enabled"`
+	DHCP    bool               `json:"dhcp"`
+	Address []NMStateIPAddress `json:"address"`
+}
+
+// NMStateIPAddress is a static address of an nmstate interface
+type NMStateIPAddress struct {
+	IP           string `json:"ip"`
+	PrefixLength int    `json:"prefix-length"`
+}
+
+// InstallConfig is the part of an install-config.yaml describing the cluster and its baremetal hosts
+type InstallConfig struct {
+	Metadata     metav1.ObjectMeta `json:"metadata"`
+	BaseDomain   string            `json:"baseDomain"`
+	ControlPlane *MachinePool      `json:"controlPlane"`
+	Compute      []MachinePool     `json:"compute"`
+	Platform     struct {
+		BareMetal *BareMetalPlatform `json:"baremetal"`
+	} `json:"platform"`
+}
+
+// MachinePool is the controlPlane or a compute pool of an install-config.yaml
+type MachinePool struct {
+	Name     string `json:"name"`
+	Replicas *int32 `json:"replicas"`
+}
+
+// BareMetalPlatform lists the hosts of a baremetal install-config.yaml
+type BareMetalPlatform struct {
+	Hosts []BareMetalHost `json:"hosts"`
+}
+
+// BareMetalHost is a host of a baremetal install-config.yaml
+type BareMetalHost struct {
+	Name           string `json:"name"`
+	Role           string `json:"role"`
+	BootMACAddress string `json:"bootMACAddress"`
+	BMC            BMC    `json:"bmc"`
+}
+
+// BMC of a baremetal install-config.yaml host
+type BMC struct {
+	Address                        string `json:"address"`
+	Username                       string `json:"username"`
+	Password                       string `json:"password"`
+	DisableCertificateVerification bool   `json:"disableCertificateVerification"`
+}
+
+// Options of the generated objects
+type Options struct {
+	// Namespace of the machines
+	Namespace string
+	// Infrastructure name used in object names and the cluster label, defaults to the cluster name
+	InfraID string
+	// Sets spec.providerID of the machines when not nil
+	ProviderIDTemplate *template.Template
+	// Also generate a MachineSet for each role other than master
+	MachineSets bool
+}
+
+// Read a YAML file into v
+func LoadFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// A host merged from the agent-config.yaml and install-config.yaml
+type host struct {
+	// Name the hosts of both files are matched by
+	name string
+	// Only set from the agent-config.yaml, install-config.yaml host names are not necessarily node names
+	hostname string
+	role     string
+	ip       string
+	macs     []string
+	bmc      *BMC
+}
+
+// Generate Secrets for BMC credentials, Machines and optionally MachineSets for the hosts
+// Either file may be nil. Hosts of both files are matched by name or MAC address.
+func Generate(agentConfig *AgentConfig, installConfig *InstallConfig, opts Options) ([]client.Object, error) {
+	hosts, err := mergeHosts(agentConfig, installConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts found")
+	}
+	infraID := opts.InfraID
+	if infraID == "" && installConfig != nil {
+		infraID = installConfig.Metadata.Name
+	}
+	if infraID == "" && agentConfig != nil {
+		infraID = agentConfig.Metadata.Name
+	}
+	if infraID == "" {
+		return nil, fmt.Errorf("no cluster name found, an infrastructure name is required")
+	}
+	assignRoles(hosts, installConfig)
+
+	var secrets, machines []client.Object
+	counts := map[string]int{}
+	var roles []string
+	for _, h := range hosts {
+		name := fmt.Sprintf("%s-%s-%d", infraID, h.role, counts[h.role])
+		if counts[h.role] == 0 {
+			roles = append(roles, h.role)
+		}
+		counts[h.role]++
+
+		m := &machinev1.Machine{
+			TypeMeta: metav1.TypeMeta{APIVersion: machinev1.GroupVersion.String(), Kind: "Machine"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   opts.Namespace,
+				Labels:      machineLabels(infraID, h.role, opts.MachineSets),
+				Annotations: map[string]string{},
+			},
+		}
+		if h.hostname != "" {
+			m.Annotations[annotationKey(controller.HostnameAnnotation)] = h.hostname
+			m.Annotations[annotationKey(controller.NodeNameAnnotation)] = h.hostname
+		}
+		if h.ip != "" {
+			m.Annotations[annotationKey(controller.InternalIPAnnotation)] = h.ip
+		}
+		if len(h.macs) > 0 {
+			m.Annotations[annotationKey(controller.MACAddressAnnotation)] = strings.Join(h.macs, ",")
+		}
+		if address, ok := redfishAddress(h.bmc); ok {
+			m.Annotations[annotationKey(controller.BMCAddressAnnotation)] = address
+			if h.bmc.DisableCertificateVerification {
+				m.Annotations[annotationKey(controller.BMCDisableCertificateVerificationAnnotation)] = "true"
+			}
+			if h.bmc.Username != "" {
+				secretName := name + "-bmc"
+				m.Annotations[annotationKey(controller.BMCCredentialsAnnotation)] = secretName
+				secrets = append(secrets, &corev1.Secret{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+					ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: opts.Namespace},
+					Type:       controller.BMCCredentialsSecretType,
+					StringData: map[string]string{
+						controller.BMCUsernameKey: h.bmc.Username,
+						controller.BMCPasswordKey: h.bmc.Password,
+					},
+				})
+			}
+		}
+		if opts.ProviderIDTemplate != nil {
+			providerID, err := controller.ExecuteProviderIDTemplate(opts.ProviderIDTemplate, m)
+			if err != nil {
+				return nil, err
+			}
+			m.Spec.ProviderID = &providerID
+		}
+		machines = append(machines, m)
+	}
+
+	objs := append(secrets, machines...)
+	if opts.MachineSets {
+		for _, role := range roles {
+			if role != roleMaster {
+				objs = append(objs, machineSet(infraID, role, opts.Namespace, int32(counts[role])))
+			}
+		}
+	}
+	return objs, nil
+}
+
+// Hosts of the agent-config.yaml, completed with the BMC of the matching install-config.yaml host
+// Hosts only found in the install-config.yaml are added after them
+func mergeHosts(agentConfig *AgentConfig, installConfig *InstallConfig) ([]*host, error) {
+	var hosts []*host
+	if agentConfig != nil {
+		for _, ah := range agentConfig.Hosts {
+			h := &host{name: ah.Hostname, hostname: ah.Hostname, role: ah.Role, ip: staticIP(ah.NetworkConfig)}
+			var macs []string
+			for _, i := range ah.Interfaces {
+				macs = append(macs, i.MACAddress)
+			}
+			for _, i := range ah.NetworkConfig.Interfaces {
+				if i.MACAddress != "" {
+					macs = append(macs, i.MACAddress)
+				}
+			}
+			var err error
+			if h.macs, err = canonicalMACs(macs); err != nil {
+				return nil, fmt.Errorf("host %q: %w", ah.Hostname, err)
+			}
+			hosts = append(hosts, h)
+		}
+	}
+	if installConfig == nil || installConfig.Platform.BareMetal == nil {
+		return hosts, nil
+	}
+	for i := range installConfig.Platform.BareMetal.Hosts {
+		bh := &installConfig.Platform.BareMetal.Hosts[i]
+		macs, err := canonicalMACs([]string{bh.BootMACAddress})
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %w", bh.Name, err)
+		}
+		h := findHost(hosts, bh.Name, macs)
+		if h == nil {
+			h = &host{name: bh.Name, macs: macs}
+			hosts = append(hosts, h)
+		}
+		if h.role == "" {
+			h.role = bh.Role
+		}
+		h.bmc = &bh.BMC
+	}
+	return hosts, nil
+}
+
+func findHost(hosts []*host, name string, macs []string) *host {
+	for _, h := range hosts {
+		if name != "" && h.name == name {
+			return h
+		}
+		for _, mac := range macs {
+			for _, hmac := range h.macs {
+				if mac == hmac {
+					return h
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Give hosts without a role the master role until the control plane replicas are reached, as the installer does,
+// and the worker role after that
+func assignRoles(hosts []*host, installConfig *InstallConfig) {
+	masters := 3
+	if installConfig != nil && installConfig.ControlPlane != nil && installConfig.ControlPlane.Replicas != nil {
+		masters = int(*installConfig.ControlPlane.Replicas)
+	}
+	for _, h := range hosts {
+		if h.role == roleMaster {
+			masters--
+		}
+	}
+	for _, h := range hosts {
+		if h.role != "" {
+			continue
+		}
+		if masters > 0 {
+			h.role = roleMaster
+			masters--
+		} else {
+			h.role = roleWorker
+		}
+	}
+}
+
+// Address of an installer BMC for the Redfish client, only Redfish BMCs are supported
+func redfishAddress(bmc *BMC) (string, bool) {
+	if bmc == nil {
+		return "", false
+	}
+	scheme, rest, ok := strings.Cut(bmc.Address, "://")
+	if !ok {
+		return "", false
+	}
+	driver, transport, _ := strings.Cut(scheme, "+")
+	switch driver {
+	case "redfish", "redfish-virtualmedia", "idrac-redfish", "idrac-virtualmedia":
+	default:
+		return "", false
+	}
+	if transport != "" {
+		return "redfish+" + transport + "://" + rest, true
+	}
+	return "redfish://" + rest, true
+}
+
+// First static address of the nmstate configuration, IPv4 before IPv6
+func staticIP(nc NetworkConfig) string {
+	for _, family := range []func(NMStateInterface) NMStateIP{
+		func(i NMStateInterface) NMStateIP { return i.IPv4 },
+		func(i NMStateInterface) NMStateIP { return i.IPv6 },
+	} {
+		for _, i := range nc.Interfaces {
+			ip := family(i)
+			if !ip.Enabled || i.State == "down" || i.State == "absent" {
+				continue
+			}
+			for _, a := range ip.Address {
+				if net.ParseIP(a.IP) != nil {
+					return a.IP
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Lowercase colon separated MAC addresses, deduplicated and sorted
+func canonicalMACs(values []string) ([]string, error) {
+	seen := map[string]bool{}
+	var macs []string
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		hw, err := net.ParseMAC(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q", value)
+		}
+		if mac := hw.String(); !seen[mac] {
+			seen[mac] = true
+			macs = append(macs, mac)
+		}
+	}
+	sort.Strings(macs)
+	return macs, nil
+}
+
+func machineLabels(infraID, role string, machineSets bool) map[string]string {
+	labels := map[string]string{
+		clusterLabel:                infraID,
+		controller.MachineRoleLabel: role,
+		machineTypeLabel:            role,
+	}
+	if machineSets && role != roleMaster {
+		labels[machineSetLabel] = fmt.Sprintf("%s-%s", infraID, role)
+	}
+	return labels
+}
+
+// MachineSet selecting the machines of a role, it adopts the generated machines
+func machineSet(infraID, role, namespace string, replicas int32) *machinev1.MachineSet {
+	name := fmt.Sprintf("%s-%s", infraID, role)
+	selector := map[string]string{clusterLabel: infraID, machineSetLabel: name}
+	return &machinev1.MachineSet{
+		TypeMeta: metav1.TypeMeta{APIVersion: machinev1.GroupVersion.String(), Kind: "MachineSet"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{clusterLabel: infraID},
+		},
+		Spec: machinev1.MachineSetSpec{
+			Replicas: ptr.To(replicas),
+			Selector: metav1.LabelSelector{MatchLabels: selector},
+			Template: machinev1.MachineTemplateSpec{
+				ObjectMeta: machinev1.ObjectMeta{Labels: machineLabels(infraID, role, true)},
+			},
+		},
+	}
+}
+
+// Full key of a machine-node-linker.github.com/ annotation, several functions here have an annotations variable
+var annotationKey = annotations.Key
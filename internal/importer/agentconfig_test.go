@@ -0,0 +1,144 @@
+package importer
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/controller"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Agent config import", func() {
+
+	var (
+		agentConfig   *AgentConfig
+		installConfig *InstallConfig
+		opts          Options
+	)
+
+	BeforeEach(func() {
+		agentConfig = &AgentConfig{}
+		Expect(LoadFile("testdata/agent-config.yaml", agentConfig)).Should(Succeed())
+		installConfig = &InstallConfig{}
+		Expect(LoadFile("testdata/install-config.yaml", installConfig)).Should(Succeed())
+		opts = Options{Namespace: "openshift-machine-api"}
+	})
+
+	machines := func(objs []client.Object) map[string]*machinev1.Machine {
+		result := map[string]*machinev1.Machine{}
+		for _, obj := range objs {
+			if m, ok := obj.(*machinev1.Machine); ok {
+				result[m.Name] = m
+			}
+		}
+		return result
+	}
+
+	It("Should generate annotated machines for the hosts", func() {
+		objs, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ms := machines(objs)
+		Expect(ms).Should(HaveLen(2))
+		master := ms["ostest-master-0"]
+		Expect(master).ShouldNot(BeNil())
+		Expect(master.Labels).Should(HaveKeyWithValue(controller.MachineRoleLabel, "master"))
+		Expect(master.Annotations).Should(HaveKeyWithValue(annotationKey(controller.InternalIPAnnotation), "192.168.111.80"))
+		Expect(master.Annotations).Should(HaveKeyWithValue(annotationKey(controller.NodeNameAnnotation), "master-0"))
+		Expect(master.Annotations).Should(HaveKeyWithValue(annotationKey(controller.MACAddressAnnotation), "00:ef:44:21:e6:a5"))
+		Expect(master.Spec.ProviderID).Should(BeNil())
+
+		worker := ms["ostest-worker-0"]
+		Expect(worker).ShouldNot(BeNil())
+		Expect(worker.Labels).Should(HaveKeyWithValue(controller.MachineRoleLabel, "worker"))
+		Expect(worker.Annotations).Should(HaveKeyWithValue(annotationKey(controller.InternalIPAnnotation), "fd2e:6f44:5dd8::90"))
+		Expect(worker.Annotations).Should(HaveKeyWithValue(annotationKey(controller.BMCAddressAnnotation), "redfish://10.0.9.2/redfish/v1/Systems/1"))
+		Expect(worker.Annotations).Should(HaveKeyWithValue(annotationKey(controller.BMCCredentialsAnnotation), "ostest-worker-0-bmc"))
+
+		Expect(objs[0]).Should(BeAssignableToTypeOf(&corev1.Secret{}))
+		Expect(objs[0].(*corev1.Secret).StringData).Should(HaveKeyWithValue(controller.BMCPasswordKey, "secret"))
+		Expect(objs[0].(*corev1.Secret).Type).Should(Equal(controller.BMCCredentialsSecretType))
+	})
+
+	It("Should set providerIDs from the template", func() {
+		var err error
+		opts.ProviderIDTemplate, err = controller.ParseProviderIDTemplate("agent:///{{ .Hostname }}")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		objs, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(machines(objs)["ostest-master-0"].Spec.ProviderID).Should(HaveValue(Equal("agent:///master-0")))
+	})
+
+	It("Should generate MachineSets adopting the machines", func() {
+		opts.MachineSets = true
+		opts.InfraID = "ostest-x7k2p"
+		objs, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ms, ok := objs[len(objs)-1].(*machinev1.MachineSet)
+		Expect(ok).Should(BeTrue())
+		Expect(ms.Name).Should(Equal("ostest-x7k2p-worker"))
+		Expect(ms.Spec.Replicas).Should(Equal(ptr.To(int32(1))))
+		worker := machines(objs)["ostest-x7k2p-worker-0"]
+		Expect(worker).ShouldNot(BeNil())
+		Expect(worker.Labels).Should(HaveKeyWithValue(machineSetLabel, ms.Name))
+		for key, value := range ms.Spec.Selector.MatchLabels {
+			Expect(worker.Labels).Should(HaveKeyWithValue(key, value))
+		}
+		Expect(machines(objs)["ostest-x7k2p-master-0"].Labels).ShouldNot(HaveKey(machineSetLabel))
+	})
+
+	It("Should assign control plane roles to hosts without a role", func() {
+		agentConfig.Hosts[0].Role = ""
+		installConfig.Platform.BareMetal = nil
+		installConfig.ControlPlane.Replicas = ptr.To(int32(1))
+		objs, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(machines(objs)).Should(HaveKey("ostest-master-0"))
+		Expect(machines(objs)).Should(HaveKey("ostest-worker-0"))
+	})
+
+	It("Should not name nodes after hosts only in the install-config", func() {
+		agentConfig.Hosts = agentConfig.Hosts[:1]
+		objs, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		worker := machines(objs)["ostest-worker-0"]
+		Expect(worker).ShouldNot(BeNil())
+		Expect(worker.Annotations).Should(HaveKey(annotationKey(controller.BMCAddressAnnotation)))
+		Expect(worker.Annotations).ShouldNot(HaveKey(annotationKey(controller.NodeNameAnnotation)))
+		Expect(worker.Annotations).ShouldNot(HaveKey(annotationKey(controller.HostnameAnnotation)))
+	})
+
+	It("Should skip BMCs without Redfish", func() {
+		installConfig.Platform.BareMetal.Hosts[0].BMC.Address = "ipmi://10.0.9.2"
+		objs, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(machines(objs)["ostest-worker-0"].Annotations).ShouldNot(HaveKey(annotationKey(controller.BMCAddressAnnotation)))
+	})
+
+	It("Should reject invalid MAC addresses", func() {
+		agentConfig.Hosts[0].Interfaces[0].MACAddress = "not-a-mac"
+		_, err := Generate(agentConfig, installConfig, opts)
+		Expect(err).Should(MatchError(ContainSubstring("not-a-mac")))
+	})
+
+	It("Should write the objects as YAML documents", func() {
+		objs, err := Generate(agentConfig, nil, opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		var buf bytes.Buffer
+		Expect(WriteYAML(&buf, objs)).Should(Succeed())
+		Expect(buf.String()).Should(ContainSubstring("kind: Machine\n"))
+		Expect(buf.String()).Should(ContainSubstring("---\n"))
+		Expect(buf.String()).ShouldNot(ContainSubstring("creationTimestamp"))
+		Expect(buf.String()).ShouldNot(ContainSubstring("status:"))
+	})
+})
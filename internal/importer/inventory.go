@@ -0,0 +1,387 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/controller"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatYAML = "yaml"
+)
+
+// InventoryEntry is a machine of a CSV or YAML inventory
+type InventoryEntry struct {
+	Name          string   `json:"name"`
+	InternalIP    string   `json:"internalIP,omitempty"`
+	InternalDNS   string   `json:"internalDNS,omitempty"`
+	Hostname      string   `json:"hostname,omitempty"`
+	MACAddresses  []string `json:"macAddresses,omitempty"`
+	Role          string   `json:"role,omitempty"`
+	ProviderState string   `json:"providerState,omitempty"`
+	ProviderID    string   `json:"providerID,omitempty"`
+	// Node the machine is linked to
+	Node string `json:"node,omitempty"`
+}
+
+// CSV columns, in the order they are exported
+var inventoryColumns = []string{"name", "internalIP", "internalDNS", "hostname", "macAddresses", "role", "providerState", "providerID", "node"}
+
+// Inventory format of a file from its extension, yaml unless it ends in .csv
+func FormatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return FormatCSV
+	}
+	return FormatYAML
+}
+
+// Read and validate an inventory
+// A CSV inventory starts with a header naming its columns, MAC addresses are comma separated within a field
+func ReadInventory(r io.Reader, format string) ([]InventoryEntry, error) {
+	var entries []InventoryEntry
+	switch format {
+	case FormatCSV:
+		var err error
+		if entries, err = readCSV(r); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+			return nil, fmt.Errorf("unable to parse inventory: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown inventory format %q", format)
+	}
+
+	seen := map[string]bool{}
+	for i := range entries {
+		e := &entries[i]
+		if e.Name == "" {
+			return nil, fmt.Errorf("entry %d: name is required", i+1)
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("machine %q: listed more than once", e.Name)
+		}
+		seen[e.Name] = true
+		if e.InternalIP != "" && net.ParseIP(e.InternalIP) == nil {
+			return nil, fmt.Errorf("machine %q: invalid internalIP %q", e.Name, e.InternalIP)
+		}
+		var err error
+		if e.MACAddresses, err = canonicalMACs(e.MACAddresses); err != nil {
+			return nil, fmt.Errorf("machine %q: %w", e.Name, err)
+		}
+	}
+	return entries, nil
+}
+
+func readCSV(r io.Reader) ([]InventoryEntry, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory header: %w", err)
+	}
+	for _, column := range header {
+		if !slices.Contains(inventoryColumns, column) {
+			return nil, fmt.Errorf("unknown inventory column %q", column)
+		}
+	}
+	if !slices.Contains(header, "name") {
+		return nil, errors.New("inventory has no name column")
+	}
+
+	var entries []InventoryEntry
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read inventory: %w", err)
+		}
+		e := InventoryEntry{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch header[i] {
+			case "name":
+				e.Name = value
+			case "internalIP":
+				e.InternalIP = value
+			case "internalDNS":
+				e.InternalDNS = value
+			case "hostname":
+				e.Hostname = value
+			case "macAddresses":
+				if value != "" {
+					e.MACAddresses = strings.Split(value, ",")
+				}
+			case "role":
+				e.Role = value
+			case "providerState":
+				e.ProviderState = value
+			case "providerID":
+				e.ProviderID = value
+			case "node":
+				e.Node = value
+			}
+		}
+		entries = append(entries, e)
+	}
+}
+
+// Write an inventory as CSV with a header, or as a YAML list
+func WriteInventory(w io.Writer, format string, entries []InventoryEntry) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(inventoryColumns); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			record := []string{e.Name, e.InternalIP, e.InternalDNS, e.Hostname, strings.Join(e.MACAddresses, ","), e.Role, e.ProviderState, e.ProviderID, e.Node}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatYAML:
+		if entries == nil {
+			entries = []InventoryEntry{}
+		}
+		data, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown inventory format %q", format)
+	}
+}
+
+// Annotations and labels an entry sets on its machine, fields left empty are not changed
+func (e *InventoryEntry) metadata() (map[string]string, map[string]string) {
+	annotations := map[string]string{}
+	for key, value := range map[string]string{
+		controller.InternalIPAnnotation:    e.InternalIP,
+		controller.InternalDNSAnnotation:   e.InternalDNS,
+		controller.HostnameAnnotation:      e.Hostname,
+		controller.MACAddressAnnotation:    strings.Join(e.MACAddresses, ","),
+		controller.ProviderStateAnnotation: e.ProviderState,
+		controller.ProviderIDAnnotation:    e.ProviderID,
+		controller.NodeNameAnnotation:      e.Node,
+	} {
+		if value != "" {
+			annotations[annotationKey(key)] = value
+		}
+	}
+	labels := map[string]string{}
+	if e.Role != "" {
+		labels[controller.MachineRoleLabel] = e.Role
+		labels[machineTypeLabel] = e.Role
+	}
+	return annotations, labels
+}
+
+// Create the machines of the inventory that do not exist and patch the annotations and role labels of the others
+// The changes are written to out as a diff, with dryRun nothing is changed in the cluster
+func ImportInventory(ctx context.Context, c client.Client, namespace string, entries []InventoryEntry, dryRun bool, out io.Writer) error {
+	for i := range entries {
+		e := &entries[i]
+		annotations, labels := e.metadata()
+
+		m := &machinev1.Machine{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: e.Name}, m)
+		switch {
+		case apierrors.IsNotFound(err):
+			m = &machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        e.Name,
+					Namespace:   namespace,
+					Annotations: annotations,
+					Labels:      labels,
+				},
+			}
+			fmt.Fprintf(out, "machine/%s created\n", e.Name)
+			writeDiff(out, nil, annotations)
+			writeDiff(out, nil, labels)
+			if dryRun {
+				continue
+			}
+			if err := c.Create(ctx, m); err != nil {
+				return fmt.Errorf("unable to create machine %q: %w", e.Name, err)
+			}
+		case err != nil:
+			return fmt.Errorf("unable to get machine %q: %w", e.Name, err)
+		default:
+			if !changes(m.Annotations, annotations) && !changes(m.Labels, labels) {
+				fmt.Fprintf(out, "machine/%s unchanged\n", e.Name)
+				continue
+			}
+			fmt.Fprintf(out, "machine/%s patched\n", e.Name)
+			writeDiff(out, m.Annotations, annotations)
+			writeDiff(out, m.Labels, labels)
+			if dryRun {
+				continue
+			}
+			patch := client.MergeFrom(m.DeepCopy())
+			m.Annotations = merge(m.Annotations, annotations)
+			m.Labels = merge(m.Labels, labels)
+			if err := c.Patch(ctx, m, patch); err != nil {
+				return fmt.Errorf("unable to patch machine %q: %w", e.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Build an inventory from the linker-managed machines of the namespace and the nodes they are linked to
+// Values missing from the annotations are taken from the machine status
+func ExportInventory(ctx context.Context, c client.Client, namespace string) ([]InventoryEntry, error) {
+	machines := &machinev1.MachineList{}
+	if err := c.List(ctx, machines, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list machines: %w", err)
+	}
+	entries := []InventoryEntry{}
+	for _, m := range machines.Items {
+		// Machines of other providers would be taken over when the inventory is imported again
+		if !hasLinkerAnnotations(&m) {
+			continue
+		}
+		annotation := func(key string) string {
+			return m.Annotations[annotationKey(key)]
+		}
+		e := InventoryEntry{
+			Name:          m.Name,
+			InternalIP:    annotation(controller.InternalIPAnnotation),
+			InternalDNS:   annotation(controller.InternalDNSAnnotation),
+			Hostname:      annotation(controller.HostnameAnnotation),
+			Role:          m.Labels[controller.MachineRoleLabel],
+			ProviderState: annotation(controller.ProviderStateAnnotation),
+			ProviderID:    annotation(controller.ProviderIDAnnotation),
+			Node:          annotation(controller.NodeNameAnnotation),
+		}
+		if macs := annotation(controller.MACAddressAnnotation); macs != "" {
+			e.MACAddresses = strings.Split(macs, ",")
+		}
+		if e.ProviderID == "" && m.Spec.ProviderID != nil {
+			e.ProviderID = *m.Spec.ProviderID
+		}
+		if m.Status.NodeRef != nil {
+			e.Node = m.Status.NodeRef.Name
+		}
+		for _, a := range m.Status.Addresses {
+			switch {
+			case a.Type == corev1.NodeInternalIP && e.InternalIP == "":
+				e.InternalIP = a.Address
+			case a.Type == corev1.NodeHostName && e.Hostname == "":
+				e.Hostname = a.Address
+			}
+		}
+		for _, a := range m.Status.Addresses {
+			// The hostname annotation also sets the InternalDNS address
+			if a.Type == corev1.NodeInternalDNS && e.InternalDNS == "" && a.Address != e.Hostname {
+				e.InternalDNS = a.Address
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Desired values that differ from the current ones
+func changes(current, desired map[string]string) bool {
+	for key, value := range desired {
+		if current[key] != value {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the desired values that differ from the current ones, sorted by key
+func writeDiff(out io.Writer, current, desired map[string]string) {
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		old, ok := current[key]
+		if ok && old == desired[key] {
+			continue
+		}
+		if ok {
+			fmt.Fprintf(out, "- %s: %s\n", key, old)
+		}
+		fmt.Fprintf(out, "+ %s: %s\n", key, desired[key])
+	}
+}
+
+func merge(current, desired map[string]string) map[string]string {
+	if current == nil {
+		current = map[string]string{}
+	}
+	for key, value := range desired {
+		current[key] = value
+	}
+	return current
+}
+
+// Machine carries at least one machine-node-linker.github.com/ annotation
+func hasLinkerAnnotations(m *machinev1.Machine) bool {
+	for key := range m.Annotations {
+		if strings.HasPrefix(key, annotationKey("")) {
+			return true
+		}
+	}
+	return false
+}
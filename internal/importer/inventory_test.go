@@ -0,0 +1,144 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubectl/pkg/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/machine-node-linker/machine-node-linker/internal/controller"
+)
+
+// +kubebuilder:docs-gen:collapse=Imports
+//
+//nolint:all
+var _ = Describe("Inventory", func() {
+
+	const MachineNamespace = "openshift-machine-api"
+
+	var (
+		ctx = context.Background()
+		c   client.Client
+		out *bytes.Buffer
+	)
+
+	const inventoryCSV = `name,internalIP,hostname,macAddresses,role
+worker-0,10.0.0.5,worker-0.example.com,"52:54:00:AA:BB:01,52:54:00:aa:bb:02",worker
+worker-1,10.0.0.6,,,
+`
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		c = fake.NewClientBuilder().
+			WithScheme(scheme.Scheme).
+			WithObjects(&machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "worker-1",
+					Namespace:   MachineNamespace,
+					Annotations: map[string]string{annotationKey(controller.InternalIPAnnotation): "10.0.0.9"},
+				},
+			}).
+			WithStatusSubresource(&machinev1.Machine{}).
+			Build()
+	})
+
+	getMachine := func(name string) *machinev1.Machine {
+		m := &machinev1.Machine{}
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: MachineNamespace, Name: name}, m)).Should(Succeed())
+		return m
+	}
+
+	It("Should read a CSV inventory", func() {
+		entries, err := ReadInventory(strings.NewReader(inventoryCSV), FormatCSV)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(HaveLen(2))
+		Expect(entries[0]).Should(Equal(InventoryEntry{
+			Name:         "worker-0",
+			InternalIP:   "10.0.0.5",
+			Hostname:     "worker-0.example.com",
+			MACAddresses: []string{"52:54:00:aa:bb:01", "52:54:00:aa:bb:02"},
+			Role:         "worker",
+		}))
+	})
+
+	It("Should reject invalid inventories", func() {
+		_, err := ReadInventory(strings.NewReader("name,rack\nworker-0,r1\n"), FormatCSV)
+		Expect(err).Should(MatchError(ContainSubstring(`unknown inventory column "rack"`)))
+		_, err = ReadInventory(strings.NewReader("- name: worker-0\n  internalIP: 10.0.0\n"), FormatYAML)
+		Expect(err).Should(MatchError(ContainSubstring("invalid internalIP")))
+		_, err = ReadInventory(strings.NewReader("- name: worker-0\n- name: worker-0\n"), FormatYAML)
+		Expect(err).Should(MatchError(ContainSubstring("more than once")))
+	})
+
+	It("Should create and patch machines", func() {
+		entries, err := ReadInventory(strings.NewReader(inventoryCSV), FormatCSV)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ImportInventory(ctx, c, MachineNamespace, entries, false, out)).Should(Succeed())
+
+		m := getMachine("worker-0")
+		Expect(m.Annotations).Should(HaveKeyWithValue(annotationKey(controller.InternalIPAnnotation), "10.0.0.5"))
+		Expect(m.Annotations).Should(HaveKeyWithValue(annotationKey(controller.MACAddressAnnotation), "52:54:00:aa:bb:01,52:54:00:aa:bb:02"))
+		Expect(m.Labels).Should(HaveKeyWithValue(controller.MachineRoleLabel, "worker"))
+		Expect(getMachine("worker-1").Annotations).Should(HaveKeyWithValue(annotationKey(controller.InternalIPAnnotation), "10.0.0.6"))
+		Expect(out.String()).Should(ContainSubstring("machine/worker-0 created\n"))
+		Expect(out.String()).Should(ContainSubstring("machine/worker-1 patched\n- machine-node-linker.github.com/internal-ip: 10.0.0.9\n+ machine-node-linker.github.com/internal-ip: 10.0.0.6\n"))
+
+		By("Reporting machines that already match")
+		out.Reset()
+		Expect(ImportInventory(ctx, c, MachineNamespace, entries, false, out)).Should(Succeed())
+		Expect(out.String()).Should(Equal("machine/worker-0 unchanged\nmachine/worker-1 unchanged\n"))
+	})
+
+	It("Should only print the diff on a dry run", func() {
+		entries, err := ReadInventory(strings.NewReader(inventoryCSV), FormatCSV)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ImportInventory(ctx, c, MachineNamespace, entries, true, out)).Should(Succeed())
+
+		Expect(out.String()).Should(ContainSubstring("machine/worker-0 created\n"))
+		Expect(out.String()).Should(ContainSubstring("+ machine-node-linker.github.com/internal-ip: 10.0.0.6\n"))
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: MachineNamespace, Name: "worker-0"}, &machinev1.Machine{})).ShouldNot(Succeed())
+		Expect(getMachine("worker-1").Annotations).Should(HaveKeyWithValue(annotationKey(controller.InternalIPAnnotation), "10.0.0.9"))
+	})
+
+	It("Should export machines and their nodes in the import format", func() {
+		m := getMachine("worker-1")
+		m.Spec.ProviderID = ptr.To("manual:///worker-1")
+		Expect(c.Update(ctx, m)).Should(Succeed())
+		m.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "worker-1.example.com"}
+		m.Status.Addresses = []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "worker-1.example.com"},
+			{Type: corev1.NodeInternalDNS, Address: "worker-1.example.com"},
+		}
+		Expect(c.Status().Update(ctx, m)).Should(Succeed())
+		// Machines without linker annotations belong to another provider
+		Expect(c.Create(ctx, &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "master-0", Namespace: MachineNamespace}})).Should(Succeed())
+
+		entries, err := ExportInventory(ctx, c, MachineNamespace)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(Equal([]InventoryEntry{{
+			Name:       "worker-1",
+			InternalIP: "10.0.0.9",
+			Hostname:   "worker-1.example.com",
+			ProviderID: "manual:///worker-1",
+			Node:       "worker-1.example.com",
+		}}))
+
+		for _, format := range []string{FormatCSV, FormatYAML} {
+			var buf bytes.Buffer
+			Expect(WriteInventory(&buf, format, entries)).Should(Succeed())
+			read, err := ReadInventory(&buf, format)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(read).Should(Equal(entries))
+		}
+	})
+})
@@ -0,0 +1,161 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+// Package netbox reads devices and their primary IP addresses from the NetBox REST API
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned when NetBox has no device with the given name or ID
+var ErrNotFound = errors.New("device not found")
+
+// Client of a NetBox instance
+type Client struct {
+	// Base URL of NetBox, without the /api path
+	Endpoint *url.URL
+	Token    string
+
+	HTTPClient *http.Client
+}
+
+// Device is a NetBox dcim device
+type Device struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	PrimaryIP4 *IPAddress `json:"primary_ip4"`
+	PrimaryIP6 *IPAddress `json:"primary_ip6"`
+}
+
+// IPAddress is a NetBox ipam IP address, the address includes the prefix length
+type IPAddress struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+	DNSName string `json:"dns_name"`
+}
+
+// IP of the address without its prefix length
+func (a *IPAddress) IP() string {
+	ip, _, _ := strings.Cut(a.Address, "/")
+	return ip
+}
+
+// New builds a client for the NetBox at address
+func New(address, token string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NetBox URL %q: %w", address, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid NetBox URL %q", address)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &Client{
+		Endpoint:   u,
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Device by ID
+func (c *Client) Device(ctx context.Context, id int) (*Device, error) {
+	device := &Device{}
+	if err := c.get(ctx, "/api/dcim/devices/"+strconv.Itoa(id)+"/", nil, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// Device by name, names are only unique within a site so more than one match is an error
+func (c *Client) DeviceByName(ctx context.Context, name string) (*Device, error) {
+	list := struct {
+		Count   int      `json:"count"`
+		Results []Device `json:"results"`
+	}{}
+	if err := c.get(ctx, "/api/dcim/devices/", url.Values{"name": {name}}, &list); err != nil {
+		return nil, err
+	}
+	switch len(list.Results) {
+	case 0:
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	case 1:
+		return &list.Results[0], nil
+	default:
+		return nil, fmt.Errorf("%d devices named %q", len(list.Results), name)
+	}
+}
+
+// IPAddress by ID, the primary IPs of a device do not include their DNS name
+func (c *Client) IPAddress(ctx context.Context, id int) (*IPAddress, error) {
+	ip := &IPAddress{}
+	if err := c.get(ctx, "/api/ipam/ip-addresses/"+strconv.Itoa(id)+"/", nil, ip); err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := *c.Endpoint
+	u.Path += path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("netbox GET %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("netbox GET %s: unable to read response: %w", path, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("netbox GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("netbox GET %s: unable to decode response: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+)
+
+// Environment variable holding the action for exec and job provisioners
+const ActionEnvVar = "MNL_ACTION"
+
+// ExecProvisioner runs a local executable for each action
+// The machine is written as JSON on stdin and a Result is read as JSON from stdout
+// The action is passed as the last argument and in the MNL_ACTION environment variable
+// The executable runs in the background so a slow command does not hold a reconcile worker,
+// Provision reports ErrInProgress until it has finished
+type ExecProvisioner struct {
+	Command string
+	Args    []string
+	// Limit for a single run of the command
+	Timeout time.Duration
+	// Additional attempts after a failed run
+	Retries int
+	// Wait before the first retry, doubled for each following retry
+	Backoff time.Duration
+
+	mu sync.Mutex
+	// Running or finished runs not yet collected, by machine and action
+	runs map[string]*execRun
+}
+
+// A background run of the command
+type execRun struct {
+	done chan struct{}
+	res  *Result
+	err  error
+}
+
+func (p *ExecProvisioner) Name() string {
+	return filepath.Base(p.Command)
+}
+
+func (p *ExecProvisioner) Provision(ctx context.Context, action Action, m *machinev1.Machine) (*Result, error) {
+	key := fmt.Sprintf("%s/%s/%s", m.Namespace, m.Name, action)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if run, ok := p.runs[key]; ok {
+		select {
+		case <-run.done:
+			delete(p.runs, key)
+			return run.res, run.err
+		default:
+			return nil, ErrInProgress
+		}
+	}
+
+	input, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal machine: %w", err)
+	}
+	if p.runs == nil {
+		p.runs = map[string]*execRun{}
+	}
+	run := &execRun{done: make(chan struct{})}
+	p.runs[key] = run
+	go func() {
+		defer close(run.done)
+		// Not bound to the reconcile, each attempt is limited by Timeout
+		run.res, run.err = p.runAttempts(context.Background(), action, input)
+	}()
+	return nil, ErrInProgress
+}
+
+// Run the command until it succeeds or the retries are used up
+func (p *ExecProvisioner) runAttempts(ctx context.Context, action Action, input []byte) (*Result, error) {
+	backoff := p.Backoff
+	var lastErr error
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var out []byte
+		if out, lastErr = p.run(ctx, action, input); lastErr == nil {
+			return decodeResult(out)
+		}
+	}
+	return nil, fmt.Errorf("%s failed after %d attempts: %w", action, p.Retries+1, lastErr)
+}
+
+func (p *ExecProvisioner) run(ctx context.Context, action Action, input []byte) ([]byte, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, append(append([]string(nil), p.Args...), string(action))...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", ActionEnvVar, action))
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,183 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package provision
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Keys read from the credentials secret of the HTTP provisioner, all optional
+	SecretTokenKey = "token"
+	SecretCAKey    = "ca.crt"
+
+	maxResponseBytes = 1 << 20
+)
+
+// Request body sent by the HTTP provisioner
+type Request struct {
+	Action  Action             `json:"action"`
+	Machine *machinev1.Machine `json:"machine"`
+}
+
+// HTTPProvisioner POSTs a Request to a provisioning service for each action
+// A 200 response carries a Result, a 202 response means the action is still in progress
+type HTTPProvisioner struct {
+	URL string
+	// Secret holding a bearer token, a client certificate and key for mTLS, and a CA bundle
+	KubeClient      kubernetes.Interface
+	SecretNamespace string
+	SecretName      string
+	// Limit for a single request
+	Timeout time.Duration
+	// Also POST the reconcile action for machines that have been provisioned
+	ReconcileAction bool
+
+	mu              sync.Mutex
+	client          *http.Client
+	token           string
+	resourceVersion string
+}
+
+func (p *HTTPProvisioner) Name() string {
+	if u, err := url.Parse(p.URL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "http"
+}
+
+func (p *HTTPProvisioner) RunsOnReconcile() bool {
+	return p.ReconcileAction
+}
+
+func (p *HTTPProvisioner) Provision(ctx context.Context, action Action, m *machinev1.Machine) (*Result, error) {
+	httpClient, token, err := p.httpClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&Request{Action: action, Machine: m})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal request: %w", err)
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return decodeResult(data)
+	case http.StatusNoContent:
+		return &Result{}, nil
+	case http.StatusAccepted:
+		return nil, ErrInProgress
+	}
+	if msg := strings.TrimSpace(string(data)); msg != "" {
+		return nil, fmt.Errorf("unexpected response %s: %s", resp.Status, msg)
+	}
+	return nil, fmt.Errorf("unexpected response %s", resp.Status)
+}
+
+// Build the HTTP client from the credentials secret, rebuilt when the secret changes
+func (p *HTTPProvisioner) httpClient(ctx context.Context) (*http.Client, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.SecretName == "" {
+		if p.client == nil {
+			p.client = &http.Client{}
+		}
+		return p.client, "", nil
+	}
+
+	secret, err := p.KubeClient.CoreV1().Secrets(p.SecretNamespace).Get(ctx, p.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to get secret %s/%s: %w", p.SecretNamespace, p.SecretName, err)
+	}
+	if p.client != nil && secret.ResourceVersion == p.resourceVersion {
+		return p.client, p.token, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca, ok := secret.Data[SecretCAKey]; ok {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, "", fmt.Errorf("secret %s/%s has no valid certificates in %s", p.SecretNamespace, p.SecretName, SecretCAKey)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cert, ok := secret.Data[corev1.TLSCertKey]; ok {
+		pair, err := tls.X509KeyPair(cert, secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, "", fmt.Errorf("secret %s/%s has an invalid client certificate: %w", p.SecretNamespace, p.SecretName, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	p.client = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+	p.token = strings.TrimSpace(string(secret.Data[SecretTokenKey]))
+	p.resourceVersion = secret.ResourceVersion
+	return p.client, p.token, nil
+}
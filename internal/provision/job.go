@@ -0,0 +1,217 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package provision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Environment variable holding the machine JSON in job provisioner containers
+	MachineEnvVar = "MNL_MACHINE"
+
+	// Labels set on jobs created by the job provisioner
+	JobMachineLabel = "machine-node-linker.github.com/machine"
+	JobActionLabel  = "machine-node-linker.github.com/action"
+
+	maxJobNameLength = 63
+)
+
+// JobProvisioner runs a Job built from a template for each action
+// The machine JSON and the action are passed in environment variables to every container
+// The Result is read from the termination message of the first container
+type JobProvisioner struct {
+	KubeClient kubernetes.Interface
+	Template   *batchv1.Job
+	// Namespace for the jobs, defaults to the namespace of the machine
+	Namespace string
+	// Limit for a single pod of the job, unless the template sets activeDeadlineSeconds
+	Timeout time.Duration
+	// Additional pods after a failed one, unless the template sets backoffLimit
+	Retries int
+}
+
+// LoadJobTemplate reads a Job template from a YAML or JSON file
+func LoadJobTemplate(path string) (*batchv1.Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read job template: %w", err)
+	}
+	job := &batchv1.Job{}
+	if err := yaml.UnmarshalStrict(data, job); err != nil {
+		return nil, fmt.Errorf("unable to parse job template: %w", err)
+	}
+	if len(job.Spec.Template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("job template %q has no containers", path)
+	}
+	return job, nil
+}
+
+func (p *JobProvisioner) Name() string {
+	if p.Template.Name != "" {
+		return p.Template.Name
+	}
+	return "job"
+}
+
+// Provision creates the job on the first call and returns ErrInProgress until it finishes
+// A finished job is deleted once its result has been read
+func (p *JobProvisioner) Provision(ctx context.Context, action Action, m *machinev1.Machine) (*Result, error) {
+	namespace := p.Namespace
+	if namespace == "" {
+		namespace = m.Namespace
+	}
+	name := jobName(m.Name, action)
+	jobs := p.KubeClient.BatchV1().Jobs(namespace)
+
+	job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		job, err = p.newJob(name, namespace, action, m)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("unable to create job: %w", err)
+		}
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get job: %w", err)
+	}
+
+	var res *Result
+	switch {
+	case jobHasCondition(job, batchv1.JobComplete):
+		if res, err = p.jobResult(ctx, job); err != nil {
+			return nil, err
+		}
+	case jobHasCondition(job, batchv1.JobFailed):
+		err = fmt.Errorf("job %s/%s failed", namespace, name)
+	default:
+		return nil, ErrInProgress
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	if dErr := jobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation}); dErr != nil && !apierrors.IsNotFound(dErr) {
+		return nil, fmt.Errorf("unable to delete job: %w", dErr)
+	}
+	return res, err
+}
+
+func (p *JobProvisioner) newJob(name, namespace string, action Action, m *machinev1.Machine) (*batchv1.Job, error) {
+	input, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal machine: %w", err)
+	}
+
+	job := p.Template.DeepCopy()
+	job.ObjectMeta = metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   namespace,
+		Labels:      job.Labels,
+		Annotations: job.Annotations,
+	}
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[JobMachineLabel] = m.Name
+	job.Labels[JobActionLabel] = string(action)
+
+	env := []corev1.EnvVar{
+		{Name: ActionEnvVar, Value: string(action)},
+		{Name: MachineEnvVar, Value: string(input)},
+	}
+	for i := range job.Spec.Template.Spec.Containers {
+		c := &job.Spec.Template.Spec.Containers[i]
+		c.Env = append(c.Env, env...)
+	}
+	job.Spec.Template.Spec.Containers[0].TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	if job.Spec.Template.Spec.ActiveDeadlineSeconds == nil && p.Timeout > 0 {
+		job.Spec.Template.Spec.ActiveDeadlineSeconds = ptr.To(int64(p.Timeout.Seconds()))
+	}
+	if job.Spec.BackoffLimit == nil {
+		job.Spec.BackoffLimit = ptr.To(int32(p.Retries))
+	}
+	return job, nil
+}
+
+// Read the result from the termination message of the first container of a succeeded pod
+func (p *JobProvisioner) jobResult(ctx context.Context, job *batchv1.Job) (*Result, error) {
+	pods, err := p.KubeClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", job.Name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list job pods: %w", err)
+	}
+	container := job.Spec.Template.Spec.Containers[0].Name
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == container && status.State.Terminated != nil {
+				return decodeResult([]byte(status.State.Terminated.Message))
+			}
+		}
+	}
+	return &Result{}, nil
+}
+
+func jobHasCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// Job name for a machine and action, hashed when it would exceed the name length limit
+func jobName(machineName string, action Action) string {
+	name := fmt.Sprintf("%s-%s", machineName, action)
+	if len(name) <= maxJobNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := fmt.Sprintf("-%s-%s", action, hex.EncodeToString(sum[:])[:8])
+	return strings.TrimRight(machineName[:maxJobNameLength-len(suffix)], "-.") + suffix
+}
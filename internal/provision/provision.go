@@ -0,0 +1,92 @@
+/*
+MIT License
+
+Copyright (c) [2022] [Jason Ross]
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+
+*/
+
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Action passed to a provisioner
+type Action string
+
+const (
+	ActionCreate      Action = "create"
+	ActionReprovision Action = "reprovision"
+	ActionDelete      Action = "delete"
+	// Run for a LinkerRemediation with the Hook strategy
+	ActionRemediate Action = "remediate"
+	// Run on every reconcile of a provisioned machine by provisioners implementing ReconcileProvisioner
+	ActionReconcile Action = "reconcile"
+)
+
+// Returned while an asynchronous provisioner has not finished
+// The caller should check back later with the same action
+var ErrInProgress = errors.New("provisioning in progress")
+
+// Result reported by a provisioner, all fields are optional
+type Result struct {
+	Addresses     []corev1.NodeAddress `json:"addresses,omitempty"`
+	ProviderID    *string              `json:"providerID,omitempty"`
+	InstanceState *string              `json:"instanceState,omitempty"`
+}
+
+// Provisioner runs an external provisioning step for a machine
+type Provisioner interface {
+	// Name used in conditions and events
+	Name() string
+	// Provision runs action for the machine and returns what the provisioner reported
+	Provision(ctx context.Context, action Action, m *machinev1.Machine) (*Result, error)
+}
+
+// ReconcileProvisioner is implemented by provisioners that may also run the reconcile action
+type ReconcileProvisioner interface {
+	RunsOnReconcile() bool
+}
+
+// RunsOnReconcile reports whether the reconcile action should be run with p
+func RunsOnReconcile(p Provisioner) bool {
+	rp, ok := p.(ReconcileProvisioner)
+	return ok && rp.RunsOnReconcile()
+}
+
+// Decode a Result, an empty document is an empty Result
+func decodeResult(data []byte) (*Result, error) {
+	res := &Result{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return res, nil
+	}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("unable to parse provisioner result: %w", err)
+	}
+	return res, nil
+}